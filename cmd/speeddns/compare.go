@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"speeddns/internal/output"
+)
+
+// compare command flags
+var (
+	flagCompareFormat string
+)
+
+func newCompareCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "compare old.json new.json",
+		Short: "Compare two runs saved with -f json",
+		Long: `compare matches the results of two saved runs by resolver and address and
+shows how latency, success rate and rank changed. Latency and success rate
+deltas are marked *, ** or *** when they are unlikely to be noise (p<0.05,
+p<0.01, p<0.001). Resolvers only present in one run are shown as new or gone.
+Runs saved by older versions, which have no protocol field, are taken to
+have used plain UDP.
+
+Example usage:
+  speeddns -f json -o before.json
+  # ...change ISP, router or network settings...
+  speeddns -f json -o after.json
+  speeddns compare before.json after.json`,
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE:         runCompare,
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&flagCompareFormat, "format", "f", "table",
+		"Output format: table, json, csv")
+	return cmd
+}
+
+func runCompare(cmd *cobra.Command, args []string) error {
+	before, err := readResults(args[0])
+	if err != nil {
+		return err
+	}
+	after, err := readResults(args[1])
+	if err != nil {
+		return err
+	}
+	return output.FormatCompare(os.Stdout, output.Format(flagCompareFormat), output.Compare(before, after))
+}
+
+// readResults reads a run saved with the JSON format
+func readResults(path string) (output.JSONOutput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return output.JSONOutput{}, err
+	}
+	defer f.Close()
+
+	results, err := output.ReadJSON(f)
+	if err != nil {
+		return results, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return results, nil
+}
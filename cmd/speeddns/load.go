@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"speeddns/internal/benchmark"
+	"speeddns/internal/dns"
+	"speeddns/internal/loadtest"
+	"speeddns/internal/output"
+)
+
+// load command flags
+var (
+	flagLoadQPS          int
+	flagLoadMaxQPS       int
+	flagLoadStep         int
+	flagLoadStepDuration time.Duration
+	flagLoadTimeout      time.Duration
+	flagLoadInflight     int
+	flagLoadSockets      int
+	flagLoadMaxLoss      float64
+	flagLoadMaxP99       time.Duration
+	flagLoadDomains      []string
+	flagLoadQueryType    string
+	flagLoadFormat       string
+	flagLoadOutput       string
+)
+
+func newLoadCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "load resolver...",
+		Short: "Open-loop load test at a fixed or ramped query rate",
+		Long: `load sends queries to each resolver at a fixed rate, or ramps the rate up in
+steps, without waiting for earlier queries to be answered. Every step reports
+latency percentiles, and the ramp stops at the first step whose loss or p99
+latency exceeds its threshold. Latencies are measured from the scheduled send
+time, correcting for coordinated omission.
+
+Example usage:
+  speeddns load 10.0.0.53 --qps 2000                       # Fixed rate
+  speeddns load 10.0.0.53 --qps 1000 --max-qps 20000 --step 1000  # Find saturation
+  speeddns load 10.0.0.53 tls://10.0.0.54#dns.internal --qps 500`,
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE:         runLoad,
+	}
+
+	flags := cmd.Flags()
+	flags.IntVar(&flagLoadQPS, "qps", 100,
+		"Query rate, or the starting rate when ramping")
+	flags.IntVar(&flagLoadMaxQPS, "max-qps", 0,
+		"Highest rate to ramp up to (default: fixed rate)")
+	flags.IntVar(&flagLoadStep, "step", 0,
+		"Rate increase per step when ramping (default: (max-qps - qps) / 10)")
+	flags.DurationVar(&flagLoadStepDuration, "step-duration", 10*time.Second,
+		"How long to send at each rate")
+	flags.DurationVarP(&flagLoadTimeout, "timeout", "t", 2*time.Second,
+		"Timeout after which a query counts as lost")
+	flags.IntVar(&flagLoadInflight, "max-inflight", 10000,
+		"Maximum outstanding queries; sends beyond it count as dropped")
+	flags.IntVar(&flagLoadSockets, "sockets", 4,
+		"UDP sockets to multiplex queries over (0: a new socket per query)")
+	flags.Float64Var(&flagLoadMaxLoss, "max-loss", 1,
+		"Loss percentage at which a step counts as saturated (0 disables)")
+	flags.DurationVar(&flagLoadMaxP99, "max-p99", 100*time.Millisecond,
+		"P99 latency at which a step counts as saturated (0 disables)")
+	flags.StringSliceVarP(&flagLoadDomains, "domain", "d", nil,
+		"Domains to query in rotation (default: built-in test domains)")
+	flags.StringVar(&flagLoadQueryType, "qtype", "A",
+		"Query type to send")
+	flags.StringVarP(&flagLoadFormat, "format", "f", "table",
+		"Output format: table, json, csv")
+	flags.StringVarP(&flagLoadOutput, "output", "o", "",
+		"Output file (default: stdout)")
+	return cmd
+}
+
+func runLoad(cmd *cobra.Command, args []string) error {
+	var targets []dns.Endpoint
+	for _, a := range args {
+		ep, err := dns.ParseEndpoint(a)
+		if err != nil {
+			return fmt.Errorf("invalid resolver: %w", err)
+		}
+		targets = append(targets, ep)
+	}
+
+	qtype, err := dns.ParseQueryType(flagLoadQueryType)
+	if err != nil {
+		return err
+	}
+	if flagLoadQPS < 1 {
+		return fmt.Errorf("--qps must be at least 1")
+	}
+	if flagLoadStepDuration <= 0 {
+		return fmt.Errorf("--step-duration must be positive")
+	}
+	if flagLoadInflight < 1 {
+		return fmt.Errorf("--max-inflight must be at least 1")
+	}
+
+	config := loadtest.DefaultConfig()
+	config.StartQPS = flagLoadQPS
+	config.EndQPS = flagLoadMaxQPS
+	config.StepQPS = flagLoadStep
+	if config.EndQPS > config.StartQPS && config.StepQPS <= 0 {
+		config.StepQPS = max((config.EndQPS-config.StartQPS)/10, 1)
+	}
+	config.StepDuration = flagLoadStepDuration
+	config.MaxInflight = flagLoadInflight
+	config.MaxLoss = flagLoadMaxLoss / 100
+	config.MaxP99 = flagLoadMaxP99
+	config.QueryType = qtype
+	config.Options = dns.Options{Timeout: flagLoadTimeout, MuxSockets: flagLoadSockets}
+	config.Domains = flagLoadDomains
+	if len(config.Domains) == 0 {
+		config.Domains = benchmark.DefaultTestDomains()
+	}
+
+	progress := func(t dns.Endpoint, s loadtest.Step) {
+		fmt.Fprintf(os.Stderr, "%s: %d QPS, p99 %s, loss %.2f%%\n",
+			t, s.TargetQPS, s.Latency.P99.Round(time.Microsecond), s.LossRate()*100)
+	}
+	runner, err := loadtest.New(config)
+	if err != nil {
+		return err
+	}
+
+	// An interrupt stops the test and writes the steps that finished; a
+	// second one exits at once, as the default handler is restored
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop()
+			fmt.Fprintln(os.Stderr, "\nInterrupted, stopping and writing the finished steps...")
+		case <-finished:
+		}
+	}()
+
+	reports := runner.Run(ctx, targets, progress)
+	close(finished)
+	interrupted := ctx.Err() != nil
+
+	var w *os.File = os.Stdout
+	if flagLoadOutput != "" {
+		f, err := os.Create(flagLoadOutput)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := output.FormatLoad(w, output.Format(flagLoadFormat), reports); err != nil {
+		return err
+	}
+	if interrupted {
+		cmd.SilenceErrors = true // the interruption was already reported
+		return errInterrupted
+	}
+	return nil
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/netip"
 	"os"
 	"os/signal"
 	"syscall"
@@ -10,8 +14,11 @@ import (
 	"github.com/spf13/cobra"
 
 	"speeddns/internal/benchmark"
+	"speeddns/internal/dns"
 	"speeddns/internal/output"
+	"speeddns/internal/probe"
 	"speeddns/internal/resolver"
+	"speeddns/internal/slo"
 )
 
 var (
@@ -20,21 +27,44 @@ var (
 	date    = "unknown"
 )
 
+// errInterrupted is returned when the run was stopped by a signal after
+// writing partial results, to exit with the conventional status 130
+var errInterrupted = errors.New("interrupted")
+
 // CLI flags
 var (
-	flagTimeout     time.Duration
-	flagIterations  int
-	flagConcurrency int
-	flagFormat      string
-	flagOutput      string
-	flagUseTCP      bool
-	flagIPv6        bool
-	flagQuiet       bool
-	flagExtended    bool
-	flagResolvers   []string
-	flagDomains     []string
-	flagListOnly    bool
-	flagPrimaryOnly bool
+	flagTimeout       time.Duration
+	flagIterations    int
+	flagConcurrency   int
+	flagFormat        string
+	flagOutput        string
+	flagUseTCP        bool
+	flagProtocols     []string
+	flagDoHPost       bool
+	flagIPv6          bool
+	flagQuiet         bool
+	flagExtended      bool
+	flagResolvers     []string
+	flagDomains       []string
+	flagQueryTypes    []string
+	flagUncached      bool
+	flagUncachedZones []string
+	flagCheckAnswers  bool
+	flagReference     string
+	flagCheckNXDomain bool
+	flagCheckDNSSEC   bool
+	flagDNSSECSigned  string
+	flagDNSSECBogus   string
+	flagIdentify      bool
+	flagECS           string
+	flagECSReport     bool
+	flagSamples       bool
+	flagStream        string
+	flagAssert        []string
+	flagRules         string
+	flagBaseline      string
+	flagListOnly      bool
+	flagPrimaryOnly   bool
 )
 
 func main() {
@@ -51,7 +81,25 @@ Example usage:
   speeddns -n 10              # 10 iterations per domain
   speeddns -f json -o out.json # Output JSON to file
   speeddns -r 192.168.1.1     # Add custom resolver
-  speeddns --list             # List all built-in resolvers`,
+  speeddns -r 8.8.8.8 -r https://dns.google/dns-query  # Mix transports
+  speeddns -r [::1]:5353      # Resolver on a custom port
+  speeddns --proto udp,doh    # Compare plain DNS with DNS-over-HTTPS
+  speeddns --proto dot        # Benchmark DNS-over-TLS endpoints
+  speeddns --proto doq        # Benchmark DNS-over-QUIC endpoints
+  speeddns --qtype A,AAAA,HTTPS # Break results down by query type
+  speeddns --uncached         # Also measure cold, uncached lookups
+  speeddns --check-answers    # Flag resolvers whose answers disagree
+  speeddns --check-nxdomain   # Detect NXDOMAIN hijacking
+  speeddns --check-dnssec     # Check which resolvers validate DNSSEC
+  speeddns --identify         # Show which anycast site answered
+  speeddns --ecs 203.0.113.0/24 --ecs-report  # Measure the effect of ECS
+  speeddns --stream queries.ndjson  # Keep every query for analysis
+  speeddns --assert "p95 < 40ms" --assert "success_rate >= 99.5%"  # Gate CI on SLOs
+  speeddns --list             # List all built-in resolvers
+  speeddns verify             # Check resolvers' advertised features
+  speeddns load 10.0.0.53 --qps 1000 --max-qps 20000  # Load test
+  speeddns monitor --interval 5m  # Benchmark continuously
+  speeddns compare old.json new.json  # Compare two saved runs`,
 		Version: fmt.Sprintf("%s (commit: %s, built: %s)", version, commit, date),
 		RunE:    run,
 	}
@@ -65,11 +113,25 @@ Example usage:
 	flags.IntVarP(&flagConcurrency, "concurrency", "c", 10,
 		"Number of concurrent resolver tests")
 	flags.StringVarP(&flagFormat, "format", "f", "table",
-		"Output format: table, json, csv")
+		"Output format: table, json, csv, openmetrics, influx, graphite")
+	flags.BoolVar(&flagSamples, "samples", false,
+		"Also write every query as a raw sample (influx and graphite formats)")
+	flags.StringVar(&flagStream, "stream", "",
+		"Write every query as a line of JSON to this file while the run is in progress (- for stdout)")
+	flags.StringArrayVar(&flagAssert, "assert", nil,
+		`SLO to check, e.g. "p95 < 40ms", "Cloudflare: success_rate >= 99.5%" or "avg <= baseline+10%"; exits non-zero when violated (can be repeated)`)
+	flags.StringVar(&flagRules, "rules", "",
+		"File of SLO assertions, one per line")
+	flags.StringVar(&flagBaseline, "baseline", "",
+		"Results saved with -f json that baseline assertions compare against")
 	flags.StringVarP(&flagOutput, "output", "o", "",
 		"Output file (default: stdout)")
 	flags.BoolVar(&flagUseTCP, "tcp", false,
-		"Use TCP instead of UDP")
+		"Use TCP instead of UDP (same as --proto tcp)")
+	flags.StringSliceVar(&flagProtocols, "proto", []string{"udp"},
+		"Protocols to benchmark: udp, tcp, dot, doh, doq (can be repeated)")
+	flags.BoolVar(&flagDoHPost, "doh-post", false,
+		"Send DNS-over-HTTPS queries with POST instead of GET")
 	flags.BoolVar(&flagIPv6, "ipv6", false,
 		"Include IPv6 resolver addresses")
 	flags.BoolVarP(&flagQuiet, "quiet", "q", false,
@@ -77,15 +139,47 @@ Example usage:
 	flags.BoolVar(&flagExtended, "extended", false,
 		"Use extended domain list for testing")
 	flags.StringSliceVarP(&flagResolvers, "resolver", "r", nil,
-		"Additional resolver IPs to test (can be repeated)")
+		"Additional resolvers to test: IP, [IPv6]:port, tcp://IP, tls://IP#name, https://URL, quic://host (can be repeated; duplicates are tested once)")
 	flags.StringSliceVarP(&flagDomains, "domain", "d", nil,
 		"Custom domains to query (can be repeated)")
+	flags.StringSliceVar(&flagQueryTypes, "qtype", []string{"A"},
+		"Query types to send for each domain, e.g. A,AAAA,HTTPS,MX,TXT,NS")
+	flags.BoolVar(&flagUncached, "uncached", false,
+		"Also measure uncached lookups using random names under wildcard zones")
+	flags.StringSliceVar(&flagUncachedZones, "uncached-zone", nil,
+		"Wildcard zones for uncached lookups (implies --uncached)")
+	flags.BoolVar(&flagCheckAnswers, "check-answers", false,
+		"Compare A/AAAA answers across resolvers and flag divergent, empty or suspicious ones")
+	flags.StringVar(&flagReference, "reference", "",
+		"Resolver name or address whose answers are trusted (implies --check-answers)")
+	flags.BoolVar(&flagCheckNXDomain, "check-nxdomain", false,
+		"Query random nonexistent names and flag resolvers that answer them with an address")
+	flags.BoolVar(&flagCheckDNSSEC, "check-dnssec", false,
+		"Probe whether each resolver validates DNSSEC")
+	flags.StringVar(&flagDNSSECSigned, "dnssec-signed", probe.DefaultDNSSECTest().Signed,
+		"Correctly signed name for the DNSSEC probe")
+	flags.StringVar(&flagDNSSECBogus, "dnssec-bogus", probe.DefaultDNSSECTest().Bogus,
+		"Name with broken signatures for the DNSSEC probe")
+	flags.BoolVar(&flagIdentify, "identify", false,
+		"Ask each resolver for its anycast site (NSID, CHAOS id.server) and egress IP")
+	flags.StringVar(&flagECS, "ecs", "",
+		"Send an EDNS Client Subnet option for this subnet with every query, e.g. 203.0.113.0/24")
+	flags.BoolVar(&flagECSReport, "ecs-report", false,
+		"Compare answers with and without --ecs and how far away the answered addresses are")
 	flags.BoolVarP(&flagListOnly, "list", "l", false,
 		"List built-in resolvers and exit")
 	flags.BoolVarP(&flagPrimaryOnly, "primary", "p", false,
 		"Only test primary IP of each resolver (faster)")
 
+	rootCmd.AddCommand(newVerifyCmd())
+	rootCmd.AddCommand(newLoadCmd())
+	rootCmd.AddCommand(newMonitorCmd())
+	rootCmd.AddCommand(newCompareCmd())
+
 	if err := rootCmd.Execute(); err != nil {
+		if errors.Is(err, errInterrupted) {
+			os.Exit(130)
+		}
 		os.Exit(1)
 	}
 }
@@ -96,37 +190,23 @@ func run(cmd *cobra.Command, args []string) error {
 		return listResolvers()
 	}
 
-	// Handle signals for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		fmt.Fprintln(os.Stderr, "\nInterrupted, stopping...")
-		os.Exit(130)
-	}()
-
-	// Build resolver list
-	resolvers := resolver.BuiltinResolvers()
-
-	// If primary-only mode, reduce to just primary IPs
-	if flagPrimaryOnly {
-		for i := range resolvers {
-			if len(resolvers[i].IPv4) > 1 {
-				resolvers[i].IPv4 = resolvers[i].IPv4[:1]
-			}
-			if len(resolvers[i].IPv6) > 1 {
-				resolvers[i].IPv6 = resolvers[i].IPv6[:1]
-			}
-		}
+	resolvers, err := buildResolvers(flagPrimaryOnly, flagResolvers)
+	if err != nil {
+		return err
 	}
 
-	// Add custom resolvers if specified
-	for _, r := range flagResolvers {
-		resolvers = append(resolvers, resolver.Resolver{
-			Name:     r,
-			Provider: "Custom",
-			IPv4:     []string{r},
-		})
+	// Resolve protocols; --tcp is kept as a shorthand for --proto tcp
+	protoNames := flagProtocols
+	if flagUseTCP && !cmd.Flags().Changed("proto") {
+		protoNames = []string{"tcp"}
+	}
+	protocols, err := parseProtocols(protoNames)
+	if err != nil {
+		return err
+	}
+	queryTypes, err := parseQueryTypes(flagQueryTypes)
+	if err != nil {
+		return err
 	}
 
 	// Build configuration
@@ -134,8 +214,36 @@ func run(cmd *cobra.Command, args []string) error {
 		Timeout:     flagTimeout,
 		Iterations:  flagIterations,
 		Concurrency: flagConcurrency,
-		UseTCP:      flagUseTCP,
+		Protocols:   protocols,
+		DoHPost:     flagDoHPost,
 		IncludeIPv6: flagIPv6,
+		QueryTypes:  queryTypes,
+
+		CheckAnswers: flagCheckAnswers,
+		Reference:    flagReference,
+
+		CheckNXDomain: flagCheckNXDomain,
+		Identify:      flagIdentify,
+		ECSReport:     flagECSReport,
+
+		KeepSamples: flagSamples,
+	}
+	if flagECS != "" {
+		subnet, err := netip.ParsePrefix(flagECS)
+		if err != nil {
+			return fmt.Errorf("invalid --ecs subnet: %w", err)
+		}
+		config.ClientSubnet = subnet.Masked()
+	} else if flagECSReport {
+		return fmt.Errorf("--ecs-report requires --ecs")
+	}
+	if len(flagUncachedZones) > 0 {
+		config.UncachedZones = flagUncachedZones
+	} else if flagUncached {
+		config.UncachedZones = benchmark.DefaultUncachedZones()
+	}
+	if flagCheckDNSSEC || cmd.Flags().Changed("dnssec-signed") || cmd.Flags().Changed("dnssec-bogus") {
+		config.DNSSEC = &probe.DNSSECTest{Signed: flagDNSSECSigned, Bogus: flagDNSSECBogus}
 	}
 
 	// Set domains
@@ -147,20 +255,52 @@ func run(cmd *cobra.Command, args []string) error {
 		config.Domains = benchmark.DefaultTestDomains()
 	}
 
+	rules, baseline, err := loadAssertions()
+	if err != nil {
+		return err
+	}
+	// Past this point errors are about the run, not how it was invoked
+	cmd.SilenceUsage = true
+
+	// Stream every query as it completes
+	if flagStream != "" {
+		var sw io.Writer = os.Stdout
+		if flagStream != "-" {
+			f, err := os.Create(flagStream)
+			if err != nil {
+				return fmt.Errorf("failed to create stream file: %w", err)
+			}
+			defer f.Close()
+			sw = f
+		}
+		stream := output.NewQueryStream(sw)
+		defer func() {
+			if err := stream.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing query stream: %v\n", err)
+			}
+		}()
+		config.OnQuery = stream.Write
+	}
+
+	// Create benchmark
+	b := benchmark.New(config, resolvers)
+
 	// Print test info
 	if !flagQuiet {
-		totalAddresses := 0
-		for _, r := range resolvers {
-			totalAddresses += len(r.AllAddresses(flagIPv6))
-		}
 		fmt.Fprintf(os.Stderr, "Testing %d resolvers (%d addresses) with %d domains, %d iterations each\n",
-			len(resolvers), totalAddresses, len(config.Domains), config.Iterations)
-		fmt.Fprintf(os.Stderr, "Total queries per resolver: %d\n\n", len(config.Domains)*config.Iterations)
+			len(resolvers), b.Total(), len(config.Domains), config.Iterations)
+		fmt.Fprintf(os.Stderr, "Total queries per resolver: %d\n\n", len(config.Domains)*len(config.QueryTypes)*config.Iterations)
 	}
 
-	// Create and run benchmark
-	b := benchmark.New(config, resolvers)
+	// Run benchmark
 	runner := benchmark.NewRunner(b, time.Hour) // Long timeout for full run
+	meta := output.Run{
+		Version: version,
+		Commit:  commit,
+		Built:   date,
+		Start:   time.Now(),
+		Config:  config,
+	}
 
 	// Progress callback
 	var progressCallback func(benchmark.Progress)
@@ -170,13 +310,32 @@ func run(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	results, err := runner.Execute(progressCallback)
+	// An interrupt stops the run and writes the results so far; a second
+	// one exits at once, as the default handler is restored
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop()
+			fmt.Fprintln(os.Stderr, "\nInterrupted, stopping and writing partial results...")
+		case <-finished:
+		}
+	}()
+
+	results, err := runner.Execute(ctx, progressCallback)
+	close(finished)
 	if err != nil {
 		return fmt.Errorf("benchmark failed: %w", err)
 	}
+	meta.End = time.Now()
+	meta.Partial = ctx.Err() != nil
+	meta.Hostname, _ = os.Hostname()
+	meta.Local = probe.LocalRoutes(targetAddrs(b.Targets()))
 
 	if !flagQuiet {
-		fmt.Fprintln(os.Stderr, "\n")
+		fmt.Fprint(os.Stderr, "\n\n")
 	}
 
 	// Setup output
@@ -191,8 +350,176 @@ func run(cmd *cobra.Command, args []string) error {
 	}
 
 	// Format and output results
-	formatter := output.New(output.Format(flagFormat), w)
-	return formatter.Format(results)
+	formatter := output.New(output.Format(flagFormat), w, meta)
+	if err := formatter.Format(results); err != nil {
+		return err
+	}
+
+	if meta.Partial {
+		cmd.SilenceErrors = true // the interruption was already reported
+		return errInterrupted
+	}
+	// Violations are reported even when every query failed, so the
+	// assertions that could not be met are listed
+	var violations []slo.Violation
+	if len(rules) > 0 {
+		violations = slo.Evaluate(rules, results, baseline)
+		output.FormatViolations(os.Stderr, rules, violations)
+	}
+	if !anySucceeded(results) {
+		return fmt.Errorf("every resolver failed all queries")
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("%d SLO violation(s)", len(violations))
+	}
+	return nil
+}
+
+// loadAssertions parses the SLO assertions given with --assert and --rules,
+// and reads the baseline run they may compare against
+func loadAssertions() ([]slo.Rule, *slo.Baseline, error) {
+	var rules []slo.Rule
+	for _, text := range flagAssert {
+		rule, err := slo.Parse(text)
+		if err != nil {
+			return nil, nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if flagRules != "" {
+		f, err := os.Open(flagRules)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		fileRules, err := slo.ParseRules(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", flagRules, err)
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	if flagBaseline == "" {
+		if slo.UsesBaseline(rules) {
+			return nil, nil, fmt.Errorf("assertions relative to the baseline need --baseline")
+		}
+		return rules, nil, nil
+	}
+	saved, err := readResults(flagBaseline)
+	if err != nil {
+		return nil, nil, err
+	}
+	baseline := slo.NewBaseline()
+	for _, r := range saved.Results {
+		baseline.Add(r.Name, r.Address, r.Protocol, slo.Values{
+			slo.MetricAvg:     r.AvgMs,
+			slo.MetricMedian:  r.MedianMs,
+			slo.MetricP90:     r.P90Ms,
+			slo.MetricP95:     r.P95Ms,
+			slo.MetricP99:     r.P99Ms,
+			slo.MetricMax:     r.MaxMs,
+			slo.MetricSuccess: r.SuccessRate,
+		})
+	}
+	return rules, baseline, nil
+}
+
+// anySucceeded reports whether at least one resolver answered a query
+func anySucceeded(results []benchmark.ResolverResult) bool {
+	for _, r := range results {
+		if r.Successes > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// buildResolvers returns the built-in resolvers, reduced to their primary
+// addresses if requested, followed by the custom ones
+func buildResolvers(primaryOnly bool, custom []string) ([]resolver.Resolver, error) {
+	resolvers := resolver.BuiltinResolvers()
+
+	// If primary-only mode, reduce to just primary IPs
+	if primaryOnly {
+		for i := range resolvers {
+			if len(resolvers[i].IPv4) > 1 {
+				resolvers[i].IPv4 = resolvers[i].IPv4[:1]
+			}
+			if len(resolvers[i].IPv6) > 1 {
+				resolvers[i].IPv6 = resolvers[i].IPv6[:1]
+			}
+		}
+	}
+
+	// A custom resolver already in the list would be tested twice under the
+	// same labels, which metrics formats reject, so repeats are skipped.
+	// Plain addresses of built-in resolvers are tested the same way as
+	// the built-in.
+	seen := make(map[dns.Endpoint]bool)
+	for _, r := range resolvers {
+		for _, addr := range append(r.IPv4, r.IPv6...) {
+			seen[dns.Endpoint{Host: addr, Port: dns.DefaultPort("")}] = true
+		}
+	}
+
+	// Add custom resolvers if specified; each is tested over the transport
+	// its scheme selects, or over --proto udp/tcp when it has no scheme
+	for _, r := range custom {
+		ep, err := dns.ParseEndpoint(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resolver: %w", err)
+		}
+		if ep.Port == 0 {
+			ep.Port = dns.DefaultPort(ep.Protocol)
+		}
+		if seen[ep] {
+			continue
+		}
+		seen[ep] = true
+		resolvers = append(resolvers, resolver.Resolver{
+			Name:      r,
+			Provider:  "Custom",
+			Endpoints: []string{r},
+		})
+	}
+	return resolvers, nil
+}
+
+// targetAddrs returns the addresses of targets given as IP addresses
+func targetAddrs(targets []benchmark.Target) []netip.Addr {
+	var addrs []netip.Addr
+	for _, t := range targets {
+		if addr, err := netip.ParseAddr(t.Endpoint.Host); err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// parseProtocols converts protocol names
+func parseProtocols(names []string) ([]dns.Protocol, error) {
+	var protocols []dns.Protocol
+	for _, name := range names {
+		p, err := dns.ParseProtocol(name)
+		if err != nil {
+			return nil, err
+		}
+		protocols = append(protocols, p)
+	}
+	return protocols, nil
+}
+
+// parseQueryTypes converts query type names
+func parseQueryTypes(names []string) ([]uint16, error) {
+	var queryTypes []uint16
+	for _, name := range names {
+		qtype, err := dns.ParseQueryType(name)
+		if err != nil {
+			return nil, err
+		}
+		queryTypes = append(queryTypes, qtype)
+	}
+	return queryTypes, nil
 }
 
 func listResolvers() error {
@@ -205,6 +532,12 @@ func listResolvers() error {
 		if len(r.IPv6) > 0 {
 			fmt.Printf("  IPv6: %v\n", r.IPv6)
 		}
+		if r.DoH != "" {
+			fmt.Printf("  DoH:  %s\n", r.DoH)
+		}
+		if r.TLSName != "" {
+			fmt.Printf("  DoT:  %s\n", r.TLSName)
+		}
 		if len(r.Features) > 0 {
 			fmt.Printf("  Features: %v\n", r.Features)
 		}
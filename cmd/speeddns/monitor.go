@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"speeddns/internal/benchmark"
+	"speeddns/internal/metrics"
+	"speeddns/internal/monitor"
+	"speeddns/internal/output"
+)
+
+// monitor command flags
+var (
+	flagMonTimeout     time.Duration
+	flagMonIterations  int
+	flagMonConcurrency int
+	flagMonProtocols   []string
+	flagMonIPv6        bool
+	flagMonPrimaryOnly bool
+	flagMonResolvers   []string
+	flagMonDomains     []string
+	flagMonQueryTypes  []string
+	flagMonInterval    time.Duration
+	flagMonRate        float64
+	flagMonWindows     []time.Duration
+	flagMonReport      time.Duration
+	flagMonFormat      string
+	flagMonOutput      string
+	flagMonListen      string
+)
+
+func newMonitorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "monitor",
+		Short: "Benchmark resolvers continuously and report rolling statistics",
+		Long: `monitor runs until interrupted, either repeating the benchmark every interval
+or, with --rate, sending a steady low-rate stream of queries to every address.
+It keeps rolling windows of latency and availability per resolver address and
+prints a report of them periodically.
+
+Example usage:
+  speeddns monitor                          # Benchmark every minute
+  speeddns monitor --interval 5m -n 2       # Lighter, less frequent runs
+  speeddns monitor --rate 0.5 -r 10.0.0.53  # One query every 2s per address
+  speeddns monitor --window 1m --window 24h -f json -o monitor.ndjson
+  speeddns monitor --listen :9153           # Serve Prometheus metrics`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE:         runMonitor,
+	}
+
+	flags := cmd.Flags()
+	flags.DurationVarP(&flagMonTimeout, "timeout", "t", 5*time.Second,
+		"Timeout for each DNS query")
+	flags.IntVarP(&flagMonIterations, "iterations", "n", 5,
+		"Number of query iterations per domain in each run")
+	flags.IntVarP(&flagMonConcurrency, "concurrency", "c", 10,
+		"Number of concurrent resolver tests in each run")
+	flags.StringSliceVar(&flagMonProtocols, "proto", []string{"udp"},
+		"Protocols to monitor: udp, tcp, dot, doh, doq (can be repeated)")
+	flags.BoolVar(&flagMonIPv6, "ipv6", false,
+		"Include IPv6 resolver addresses")
+	flags.BoolVarP(&flagMonPrimaryOnly, "primary", "p", false,
+		"Only monitor primary IP of each resolver")
+	flags.StringSliceVarP(&flagMonResolvers, "resolver", "r", nil,
+		"Additional resolvers to monitor, as for the benchmark (can be repeated)")
+	flags.StringSliceVarP(&flagMonDomains, "domain", "d", nil,
+		"Custom domains to query (can be repeated)")
+	flags.StringSliceVar(&flagMonQueryTypes, "qtype", []string{"A"},
+		"Query types to send for each domain")
+	flags.DurationVar(&flagMonInterval, "interval", time.Minute,
+		"Time between the starts of benchmark runs")
+	flags.Float64Var(&flagMonRate, "rate", 0,
+		"Send a steady stream of this many queries per second to each address instead of scheduled runs")
+	flags.DurationSliceVar(&flagMonWindows, "window", monitor.DefaultConfig().Windows,
+		"Rolling windows to report statistics over (can be repeated)")
+	flags.DurationVar(&flagMonReport, "report", 0,
+		"Time between reports (default: the run interval, or 1m with --rate)")
+	flags.StringVarP(&flagMonFormat, "format", "f", "table",
+		"Output format: table, json (one report per line), csv")
+	flags.StringVarP(&flagMonOutput, "output", "o", "",
+		"Output file to append reports to (default: stdout)")
+	flags.StringVar(&flagMonListen, "listen", "",
+		"Serve Prometheus metrics on this address at /metrics, e.g. :9153")
+	return cmd
+}
+
+func runMonitor(cmd *cobra.Command, args []string) error {
+	resolvers, err := buildResolvers(flagMonPrimaryOnly, flagMonResolvers)
+	if err != nil {
+		return err
+	}
+	protocols, err := parseProtocols(flagMonProtocols)
+	if err != nil {
+		return err
+	}
+	queryTypes, err := parseQueryTypes(flagMonQueryTypes)
+	if err != nil {
+		return err
+	}
+	if flagMonRate < 0 || flagMonRate > monitor.MaxRate {
+		return fmt.Errorf("--rate must be between 0 and %d", monitor.MaxRate)
+	}
+	if flagMonInterval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	config := monitor.DefaultConfig()
+	config.Benchmark.Timeout = flagMonTimeout
+	config.Benchmark.Iterations = flagMonIterations
+	config.Benchmark.Concurrency = flagMonConcurrency
+	config.Benchmark.Protocols = protocols
+	config.Benchmark.IncludeIPv6 = flagMonIPv6
+	config.Benchmark.QueryTypes = queryTypes
+	if len(flagMonDomains) > 0 {
+		config.Benchmark.Domains = flagMonDomains
+	} else {
+		config.Benchmark.Domains = benchmark.DefaultTestDomains()
+	}
+	config.Interval = flagMonInterval
+	config.Rate = flagMonRate
+	config.Windows = flagMonWindows
+
+	report := flagMonReport
+	if report <= 0 {
+		report = flagMonInterval
+		if flagMonRate > 0 {
+			report = time.Minute
+		}
+	}
+
+	var w *os.File = os.Stdout
+	if flagMonOutput != "" {
+		f, err := os.OpenFile(flagMonOutput, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open output file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	formatter := output.NewMonitorFormatter(output.Format(flagMonFormat), w)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var collector *metrics.Collector
+	if flagMonListen != "" {
+		collector = metrics.New()
+		config.OnQuery = collector.Observe
+	}
+
+	m := monitor.New(config, resolvers)
+	fmt.Fprintf(os.Stderr, "Monitoring %d addresses, reporting every %s; interrupt to stop\n", m.Total(), report)
+
+	if collector != nil {
+		collector.SetStatus(m.Snapshot)
+		server, err := serveMetrics(flagMonListen, collector)
+		if err != nil {
+			return err
+		}
+		defer server.Close()
+		fmt.Fprintf(os.Stderr, "Serving metrics on http://%s/metrics\n", server.Addr)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- m.Run(ctx) }()
+
+	ticker := time.NewTicker(report)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+			// Final report of the windows as they stood when stopped
+			return formatter.Format(time.Now(), m.Snapshot())
+		case <-ticker.C:
+			if err := formatter.Format(time.Now(), m.Snapshot()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// serveMetrics starts an HTTP server for the collector's metrics. The
+// listener is opened before returning, so a busy port is reported at once.
+func serveMetrics(addr string, collector *metrics.Collector) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", collector.Handler())
+	server := &http.Server{
+		Addr:              ln.Addr().String(),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go server.Serve(ln)
+	return server, nil
+}
@@ -2,10 +2,13 @@ package benchmark
 
 import (
 	"context"
+	"fmt"
+	"net/netip"
 	"sync"
 	"time"
 
 	"speeddns/internal/dns"
+	"speeddns/internal/probe"
 	"speeddns/internal/resolver"
 	"speeddns/internal/stats"
 
@@ -17,9 +20,45 @@ type Config struct {
 	Timeout     time.Duration
 	Iterations  int
 	Concurrency int
-	UseTCP      bool
+	Protocols   []dns.Protocol
+	DoHPost     bool
 	IncludeIPv6 bool
 	Domains     []string
+	QueryTypes  []uint16
+
+	// UncachedZones enables cache-busting queries for random labels under
+	// these wildcard zones, measuring full recursion instead of cache hits
+	UncachedZones []string
+
+	// CheckAnswers compares A/AAAA answers across resolvers after the run.
+	// With a Reference (resolver name or address) answers are compared with
+	// that resolver's instead of the majority's.
+	CheckAnswers bool
+	Reference    string
+
+	// CheckNXDomain queries random nonexistent names to detect resolvers
+	// that rewrite NXDOMAIN into an address
+	CheckNXDomain bool
+
+	// DNSSEC enables the DNSSEC validation probe with these test names
+	DNSSEC *probe.DNSSECTest
+
+	// Identify asks each resolver address which instance answered and which
+	// egress address it used, to tell anycast sites apart
+	Identify bool
+
+	// ClientSubnet is sent as an EDNS Client Subnet option with every query.
+	// ECSReport compares answers with and without it, which requires it.
+	ClientSubnet netip.Prefix
+	ECSReport    bool
+
+	// KeepSamples keeps every timed query in the results' Samples, for
+	// formats that write raw per-query data
+	KeepSamples bool
+
+	// OnQuery, if set, is called with every timed query as it completes.
+	// Calls for different targets are made concurrently.
+	OnQuery func(Target, dns.QueryResult)
 }
 
 // DefaultConfig returns sensible defaults
@@ -28,38 +67,124 @@ func DefaultConfig() Config {
 		Timeout:     5 * time.Second,
 		Iterations:  5,
 		Concurrency: 10,
-		UseTCP:      false,
+		Protocols:   []dns.Protocol{dns.ProtoUDP},
+		DoHPost:     false,
 		IncludeIPv6: false,
 		Domains:     DefaultTestDomains(),
+		QueryTypes:  []uint16{mdns.TypeA},
 	}
 }
 
-// ResolverResult holds aggregated results for a resolver
+// ResolverResult holds aggregated results for a resolver.
+// For DoH and DoQ, queries that opened a connection are summarised in
+// Handshakes and left out of RTTs and Stats.
 type ResolverResult struct {
-	Resolver  resolver.Resolver `json:"resolver"`
-	Address   string            `json:"address"`
-	Queries   int               `json:"queries"`
-	Successes int               `json:"successes"`
-	Failures  int               `json:"failures"`
-	RTTs      []time.Duration   `json:"-"`
-	Stats     stats.Summary     `json:"stats"`
-	Errors    []string          `json:"errors,omitempty"`
+	Resolver   resolver.Resolver `json:"resolver"`
+	Address    dns.Endpoint      `json:"address"`
+	Protocol   dns.Protocol      `json:"protocol"`
+	Queries    int               `json:"queries"`
+	Successes  int               `json:"successes"`
+	Failures   int               `json:"failures"`
+	RTTs       []time.Duration   `json:"-"`
+	Stats      stats.Summary     `json:"stats"`
+	Handshakes *HandshakeStats   `json:"handshakes,omitempty"`
+	ByType     []TypeResult      `json:"by_type,omitempty"`
+	Uncached   *Series           `json:"uncached,omitempty"`
+	Errors     []string          `json:"errors,omitempty"`
+
+	// FirstLookup holds the first query for each name, which may miss the
+	// resolver's cache; RepeatLookup holds later iterations, which should hit it
+	FirstLookup  Series `json:"first_lookup"`
+	RepeatLookup Series `json:"repeat_lookup"`
+
+	// Consistency is set when answers were checked against other resolvers
+	Consistency *Consistency `json:"consistency,omitempty"`
+	answers     map[answerKey]answerSet
+
+	// NXDomain is set when nonexistent names were probed
+	NXDomain *probe.NXDomainResult `json:"nxdomain,omitempty"`
+
+	// DNSSEC is set when DNSSEC validation was probed
+	DNSSEC *probe.DNSSECResult `json:"dnssec,omitempty"`
+
+	// Identity is set when the resolver was asked to identify itself
+	Identity *probe.Identity `json:"identity,omitempty"`
+
+	// ECS is set when answers with and without client subnet were compared
+	ECS *probe.ECSResult `json:"ecs,omitempty"`
+
+	// Samples holds every timed query when KeepSamples is set
+	Samples []dns.QueryResult `json:"-"`
+
+	// Partial is set when the run was canceled before all of the
+	// resolver's queries and probes were made
+	Partial bool `json:"partial,omitempty"`
+}
+
+// Series holds the outcome of a subset of a resolver's queries
+type Series struct {
+	Queries   int             `json:"queries"`
+	Successes int             `json:"successes"`
+	Failures  int             `json:"failures"`
+	RTTs      []time.Duration `json:"-"`
+	Stats     stats.Summary   `json:"stats"`
+}
+
+// record counts a query and keeps its RTT if it succeeded. RTTs that
+// include connection setup are left out, as for the overall Stats.
+func (s *Series) record(qr dns.QueryResult) {
+	s.Queries++
+	if !qr.Success {
+		s.Failures++
+		return
+	}
+	s.Successes++
+	if qr.Handshake == dns.HandshakeNone {
+		s.RTTs = append(s.RTTs, qr.RTT)
+	}
+}
+
+// summarize calculates statistics from the recorded RTTs
+func (s *Series) summarize() {
+	s.Stats = stats.Calculate(s.RTTs)
+}
+
+// TypeResult holds the results for a single query type
+type TypeResult struct {
+	Type string `json:"type"`
+	Series
+}
+
+// HandshakeStats holds the latency of queries that had to set up a new
+// connection, split by whether the TLS session was resumed. For DoQ it
+// includes the handshake; for DoH it is the first exchange on the new
+// connection.
+type HandshakeStats struct {
+	Full    stats.Summary `json:"full"`
+	Resumed stats.Summary `json:"resumed"`
+	ZeroRTT int           `json:"zero_rtt"`
 }
 
 // Benchmark orchestrates the DNS benchmark tests
 type Benchmark struct {
 	config    Config
-	client    *dns.Client
 	resolvers []resolver.Resolver
+
+	// distance is shared by all targets so each answer address is only
+	// timed once
+	distance *probe.Distancer
 }
 
 // New creates a new Benchmark instance
 func New(config Config, resolvers []resolver.Resolver) *Benchmark {
-	return &Benchmark{
+	b := &Benchmark{
 		config:    config,
-		client:    dns.NewClient(config.Timeout, config.UseTCP),
 		resolvers: resolvers,
 	}
+	if config.ECSReport {
+		b.distance = probe.NewDistancer(config.Timeout)
+	}
+	return b
 }
 
 // Progress reports benchmark progress
@@ -70,48 +195,146 @@ type Progress struct {
 	Current  int
 }
 
+// Target is a single resolver endpoint to test
+type Target struct {
+	Resolver resolver.Resolver
+	Endpoint dns.Endpoint
+}
+
+// Targets expands the resolver list into every endpoint to test. Resolvers
+// with explicit endpoints are tested over exactly those, except that an
+// endpoint without a scheme follows the configured plain protocols (UDP/TCP).
+// The configured protocols apply in full to all other resolvers.
+func (b *Benchmark) Targets() []Target {
+	var targets []Target
+	for _, res := range b.resolvers {
+		if len(res.Endpoints) > 0 {
+			for _, s := range res.Endpoints {
+				// Endpoints are validated when the resolver is built
+				ep, err := dns.ParseEndpoint(s)
+				if err != nil {
+					continue
+				}
+				if ep.Protocol != "" {
+					targets = append(targets, Target{res, ep})
+					continue
+				}
+				for _, proto := range b.plainProtocols() {
+					ep.Protocol = proto
+					targets = append(targets, Target{res, ep})
+				}
+			}
+			continue
+		}
+		for _, proto := range b.config.Protocols {
+			switch proto {
+			case dns.ProtoDoH:
+				if res.DoH == "" {
+					continue
+				}
+				if ep, err := dns.ParseEndpoint(res.DoH); err == nil {
+					targets = append(targets, Target{res, ep})
+				}
+			case dns.ProtoDoT, dns.ProtoDoQ:
+				if res.TLSName == "" || (proto == dns.ProtoDoQ && !res.HasFeature("DoQ")) {
+					continue
+				}
+				for _, addr := range res.AllAddresses(b.config.IncludeIPv6) {
+					targets = append(targets, Target{res, dns.Endpoint{Protocol: proto, Host: addr, ServerName: res.TLSName}})
+				}
+			default:
+				for _, addr := range res.AllAddresses(b.config.IncludeIPv6) {
+					targets = append(targets, Target{res, dns.Endpoint{Protocol: proto, Host: addr}})
+				}
+			}
+		}
+	}
+	return targets
+}
+
+// plainProtocols returns the configured unencrypted protocols, defaulting to UDP
+func (b *Benchmark) plainProtocols() []dns.Protocol {
+	var protos []dns.Protocol
+	for _, p := range b.config.Protocols {
+		if p == dns.ProtoUDP || p == dns.ProtoTCP {
+			protos = append(protos, p)
+		}
+	}
+	if len(protos) == 0 {
+		protos = []dns.Protocol{dns.ProtoUDP}
+	}
+	return protos
+}
+
+// QueryTypes returns the configured query types, defaulting to A
+func (b *Benchmark) QueryTypes() []uint16 {
+	if len(b.config.QueryTypes) == 0 {
+		return []uint16{mdns.TypeA}
+	}
+	return b.config.QueryTypes
+}
+
+// ClientOptions returns the options clients are created with
+func (b *Benchmark) ClientOptions() dns.Options {
+	return dns.Options{
+		Timeout:      b.config.Timeout,
+		DoHPost:      b.config.DoHPost,
+		ClientSubnet: b.config.ClientSubnet,
+	}
+}
+
+// Total returns the number of resolver addresses the benchmark will test
+func (b *Benchmark) Total() int {
+	return len(b.Targets())
+}
+
 // Run executes the benchmark and returns results
 func (b *Benchmark) Run(ctx context.Context, progress chan<- Progress) ([]ResolverResult, error) {
 	var wg sync.WaitGroup
-	resultsChan := make(chan ResolverResult, len(b.resolvers)*4)
+	targets := b.Targets()
+	if ref := b.config.Reference; ref != "" && !hasReference(targets, ref) {
+		return nil, fmt.Errorf("reference resolver %q is not among the tested resolvers", ref)
+	}
+	if b.config.ECSReport && !b.config.ClientSubnet.IsValid() {
+		return nil, fmt.Errorf("the ECS report needs a client subnet")
+	}
+	resultsChan := make(chan ResolverResult, len(targets))
 
 	// Semaphore for concurrency control
 	sem := make(chan struct{}, b.config.Concurrency)
 
-	// Count total addresses to test
-	total := 0
-	for _, res := range b.resolvers {
-		total += len(res.AllAddresses(b.config.IncludeIPv6))
-	}
-
+	total := len(targets)
 	current := 0
 	var mu sync.Mutex
 
 	// Test each resolver
-	for _, res := range b.resolvers {
-		for _, addr := range res.AllAddresses(b.config.IncludeIPv6) {
-			wg.Add(1)
-			go func(r resolver.Resolver, address string) {
-				defer wg.Done()
-				sem <- struct{}{}        // acquire
-				defer func() { <-sem }() // release
-
-				result := b.testResolver(ctx, r, address)
-				resultsChan <- result
-
-				if progress != nil {
-					mu.Lock()
-					current++
-					progress <- Progress{
-						Resolver: r.Name,
-						Address:  address,
-						Total:    total,
-						Current:  current,
-					}
-					mu.Unlock()
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t Target) {
+			defer wg.Done()
+			sem <- struct{}{}        // acquire
+			defer func() { <-sem }() // release
+
+			// Addresses not started before cancellation are left out
+			if ctx.Err() != nil {
+				return
+			}
+
+			result := b.testResolver(ctx, t)
+			resultsChan <- result
+
+			if progress != nil {
+				mu.Lock()
+				current++
+				progress <- Progress{
+					Resolver: t.Resolver.Name,
+					Address:  t.Endpoint.String(),
+					Total:    total,
+					Current:  current,
 				}
-			}(res, addr)
-		}
+				mu.Unlock()
+			}
+		}(t)
 	}
 
 	// Close results channel when all done
@@ -126,15 +349,72 @@ func (b *Benchmark) Run(ctx context.Context, progress chan<- Progress) ([]Resolv
 		results = append(results, result)
 	}
 
+	if b.config.CheckAnswers || b.config.Reference != "" {
+		checkConsistency(results, b.config.Reference)
+	}
+
 	return results, nil
 }
 
+// hasReference reports whether any target matches the reference resolver
+func hasReference(targets []Target, ref string) bool {
+	for _, t := range targets {
+		r := ResolverResult{Resolver: t.Resolver, Address: t.Endpoint}
+		if r.matchesReference(ref) {
+			return true
+		}
+	}
+	return false
+}
+
 // testResolver runs all test queries against a single resolver address
-func (b *Benchmark) testResolver(ctx context.Context, res resolver.Resolver, addr string) ResolverResult {
+func (b *Benchmark) testResolver(ctx context.Context, t Target) ResolverResult {
 	result := ResolverResult{
-		Resolver: res,
-		Address:  addr,
-		RTTs:     make([]time.Duration, 0, b.config.Iterations*len(b.config.Domains)),
+		Resolver: t.Resolver,
+		Address:  t.Endpoint,
+		Protocol: t.Endpoint.Protocol,
+		RTTs:     make([]time.Duration, 0, b.config.Iterations*len(b.config.Domains)*len(b.QueryTypes())),
+	}
+
+	client, err := dns.NewClient(t.Endpoint, b.ClientOptions())
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+	defer client.Close()
+
+	if len(b.config.UncachedZones) > 0 {
+		result.Uncached = &Series{}
+	}
+
+	qtypes := b.QueryTypes()
+	result.ByType = make([]TypeResult, len(qtypes))
+	for i, qtype := range qtypes {
+		result.ByType[i].Type = mdns.TypeToString[qtype]
+	}
+
+	// Latencies of queries on new DoH and DoQ connections, kept apart from the
+	// query RTTs
+	var fullHandshakes, resumedHandshakes []time.Duration
+	zeroRTT := 0
+	finish := func() ResolverResult {
+		result.Stats = stats.Calculate(result.RTTs)
+		for i := range result.ByType {
+			result.ByType[i].summarize()
+		}
+		if result.Uncached != nil {
+			result.Uncached.summarize()
+		}
+		result.FirstLookup.summarize()
+		result.RepeatLookup.summarize()
+		if len(fullHandshakes)+len(resumedHandshakes) > 0 {
+			result.Handshakes = &HandshakeStats{
+				Full:    stats.Calculate(fullHandshakes),
+				Resumed: stats.Calculate(resumedHandshakes),
+				ZeroRTT: zeroRTT,
+			}
+		}
+		return result
 	}
 
 	// Early bailout: if first N queries all fail, resolver is likely unreachable
@@ -142,48 +422,121 @@ func (b *Benchmark) testResolver(ctx context.Context, res resolver.Resolver, add
 	const maxConsecutiveFailures = 3
 
 	for i := 0; i < b.config.Iterations; i++ {
+		// Reconnect at the start of every DoQ iteration: the first connection
+		// needs a full handshake, later ones can resume or use 0-RTT
+		if t.Endpoint.Protocol == dns.ProtoDoQ {
+			client.Reconnect()
+		}
+
 		for _, domain := range b.config.Domains {
-			select {
-			case <-ctx.Done():
-				result.Stats = stats.Calculate(result.RTTs)
-				return result
-			default:
-			}
+			for ti, qtype := range qtypes {
+				select {
+				case <-ctx.Done():
+					result.Partial = true
+					return finish()
+				default:
+				}
 
-			qr := b.client.Query(ctx, addr, domain, mdns.TypeA)
-			result.Queries++
-
-			if qr.Success {
-				result.Successes++
-				result.RTTs = append(result.RTTs, qr.RTT)
-				consecutiveFailures = 0 // reset on success
-			} else {
-				result.Failures++
-				consecutiveFailures++
-
-				// Early bailout: if we've never succeeded and hit max consecutive failures, give up
-				if result.Successes == 0 && consecutiveFailures >= maxConsecutiveFailures {
-					if len(result.Errors) < 5 {
-						result.Errors = append(result.Errors, "early bailout: resolver unreachable")
-					}
-					result.Stats = stats.Calculate(result.RTTs)
-					return result
+				qr := client.Query(ctx, domain, qtype)
+				if qr.Error != nil && ctx.Err() != nil {
+					// Abandoned by cancellation, not a failure of the resolver
+					result.Partial = true
+					return finish()
 				}
+				b.observe(t, &result, qr)
+				result.Queries++
+				result.ByType[ti].record(qr)
+				result.recordAnswers(qr)
+				if i == 0 {
+					result.FirstLookup.record(qr)
+				} else {
+					result.RepeatLookup.record(qr)
+				}
+
+				if qr.Success {
+					result.Successes++
+					switch qr.Handshake {
+					case dns.HandshakeFull:
+						fullHandshakes = append(fullHandshakes, qr.RTT)
+					case dns.HandshakeResumed, dns.Handshake0RTT:
+						resumedHandshakes = append(resumedHandshakes, qr.RTT)
+						if qr.Handshake == dns.Handshake0RTT {
+							zeroRTT++
+						}
+					default:
+						result.RTTs = append(result.RTTs, qr.RTT)
+					}
+					consecutiveFailures = 0 // reset on success
+				} else {
+					result.Failures++
+					consecutiveFailures++
+
+					// Early bailout: if we've never succeeded and hit max consecutive failures, give up
+					if result.Successes == 0 && consecutiveFailures >= maxConsecutiveFailures {
+						if len(result.Errors) < 5 {
+							result.Errors = append(result.Errors, "early bailout: resolver unreachable")
+						}
+						return finish()
+					}
 
-				if qr.Error != nil {
-					// Limit error collection to avoid memory issues
-					if len(result.Errors) < 5 {
-						result.Errors = append(result.Errors, qr.Error.Error())
+					if qr.Error != nil {
+						// Limit error collection to avoid memory issues
+						if len(result.Errors) < 5 {
+							result.Errors = append(result.Errors, qr.Error.Error())
+						}
 					}
 				}
 			}
 		}
+
+		// Cache-busting queries: a fresh random label under each zone forces
+		// the resolver to recurse to the zone's authoritative servers
+		for _, zone := range b.config.UncachedZones {
+			select {
+			case <-ctx.Done():
+				result.Partial = true
+				return finish()
+			default:
+			}
+
+			qr := client.Query(ctx, dns.RandomLabel()+"."+zone, mdns.TypeA)
+			if qr.Error != nil && ctx.Err() != nil {
+				result.Partial = true
+				return finish()
+			}
+			b.observe(t, &result, qr)
+			result.Uncached.record(qr)
+		}
 	}
 
+	if b.config.CheckNXDomain {
+		result.NXDomain = probe.NXDomain(ctx, client)
+	}
+	if b.config.DNSSEC != nil {
+		result.DNSSEC = probe.DNSSEC(ctx, client, *b.config.DNSSEC)
+	}
+	if b.config.Identify {
+		result.Identity = probe.Identify(ctx, client)
+	}
+	if b.config.ECSReport {
+		result.ECS = probe.ECSEffect(ctx, client, b.config.Domains, b.config.ClientSubnet, b.distance)
+	}
+	// Probes interrupted by cancellation report errors rather than findings
+	result.Partial = ctx.Err() != nil
+
 	// Calculate statistics
-	result.Stats = stats.Calculate(result.RTTs)
+	return finish()
+}
 
-	return result
+// observe keeps a query as a sample if configured and passes it to the
+// OnQuery hook
+func (b *Benchmark) observe(t Target, result *ResolverResult, qr dns.QueryResult) {
+	if b.config.KeepSamples {
+		result.Samples = append(result.Samples, qr)
+	}
+	if b.config.OnQuery != nil {
+		b.config.OnQuery(t, qr)
+	}
 }
 
 // Runner manages benchmark execution with timeout and cancellation
@@ -200,9 +553,11 @@ func NewRunner(b *Benchmark, timeout time.Duration) *Runner {
 	}
 }
 
-// Execute runs the benchmark with overall timeout
-func (r *Runner) Execute(progressCallback func(Progress)) ([]ResolverResult, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+// Execute runs the benchmark with overall timeout. When ctx is canceled,
+// the results of the addresses tested so far are returned, marked Partial
+// where they were cut short.
+func (r *Runner) Execute(ctx context.Context, progressCallback func(Progress)) ([]ResolverResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
 	var progress chan Progress
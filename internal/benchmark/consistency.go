@@ -0,0 +1,216 @@
+package benchmark
+
+import (
+	"fmt"
+	"sort"
+
+	"speeddns/internal/dns"
+	"speeddns/internal/probe"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Consistency holds the outcome of comparing a resolver's A/AAAA answers
+// with those of the other resolvers, or of the reference resolver
+type Consistency struct {
+	Checked    int      `json:"checked"`
+	Agreed     int      `json:"agreed"`
+	Divergent  []string `json:"divergent,omitempty"`
+	Empty      []string `json:"empty,omitempty"`
+	Suspicious []string `json:"suspicious,omitempty"`
+}
+
+// OK reports whether every checked answer agreed
+func (c *Consistency) OK() bool {
+	return c.Agreed == c.Checked
+}
+
+// Trustworthy reports whether the answers give no reason to distrust the
+// resolver: none were empty or pointed at a sinkhole, and at most half
+// diverged. Geo-balanced CDN names legitimately resolve to different pools,
+// so a few divergent answers are not held against a resolver.
+func (c *Consistency) Trustworthy() bool {
+	return len(c.Empty) == 0 && len(c.Suspicious) == 0 && len(c.Divergent)*2 <= c.Checked
+}
+
+// answerKey identifies a question whose answers are compared
+type answerKey struct {
+	domain string
+	qtype  uint16
+}
+
+// String returns the question as "name TYPE"
+func (k answerKey) String() string {
+	return k.domain + " " + mdns.TypeToString[k.qtype]
+}
+
+// answerSet is the set of addresses a resolver returned for a question,
+// across all iterations
+type answerSet map[string]bool
+
+// recordAnswers adds the addresses of a successful A/AAAA response to the
+// result's answer sets
+func (r *ResolverResult) recordAnswers(qr dns.QueryResult) {
+	if !qr.Success || (qr.QueryType != mdns.TypeA && qr.QueryType != mdns.TypeAAAA) {
+		return
+	}
+	if r.answers == nil {
+		r.answers = make(map[answerKey]answerSet)
+	}
+	key := answerKey{qr.Domain, qr.QueryType}
+	set := r.answers[key]
+	if set == nil {
+		set = answerSet{}
+		r.answers[key] = set
+	}
+	for _, a := range qr.Answers {
+		set[a] = true
+	}
+}
+
+// overlaps reports whether s and o share an address, or are both empty
+func (s answerSet) overlaps(o answerSet) bool {
+	if len(s) == 0 || len(o) == 0 {
+		return len(s) == len(o)
+	}
+	for a := range s {
+		if o[a] {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the addresses in sorted order
+func (s answerSet) String() string {
+	addrs := make([]string, 0, len(s))
+	for a := range s {
+		addrs = append(addrs, a)
+	}
+	sort.Strings(addrs)
+	return fmt.Sprint(addrs)
+}
+
+// suspicious reports whether s contains a sinkhole address
+func (s answerSet) suspicious() bool {
+	for a := range s {
+		if probe.IsSinkhole(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesReference reports whether r is the reference resolver, given by
+// name or address
+func (r *ResolverResult) matchesReference(ref string) bool {
+	return r.Resolver.Name == ref || r.Address.String() == ref || r.Address.Host == ref
+}
+
+// checkConsistency compares the answers of every result. With a reference,
+// answers must overlap the reference's answers; otherwise they must overlap
+// the answers of at least half of the other resolvers that responded. Each
+// resolver has one vote, whatever its number of addresses, and its own
+// addresses are not among its peers. Answers agree when they share at least
+// one address, since CDNs hand out different addresses from the same pool.
+func checkConsistency(results []ResolverResult, ref string) {
+	keys := map[answerKey]bool{}
+	for _, r := range results {
+		for k := range r.answers {
+			keys[k] = true
+		}
+	}
+	sorted := make([]answerKey, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].domain != sorted[j].domain {
+			return sorted[i].domain < sorted[j].domain
+		}
+		return sorted[i].qtype < sorted[j].qtype
+	})
+
+	for i := range results {
+		results[i].Consistency = &Consistency{}
+	}
+
+	for _, k := range sorted {
+		var refSet answerSet
+		var byResolver map[string]answerSet
+		if ref == "" {
+			// A resolver with several addresses votes with all its answers
+			byResolver = make(map[string]answerSet)
+			for _, r := range results {
+				set, ok := r.answers[k]
+				if !ok {
+					continue
+				}
+				merged := byResolver[r.Resolver.Name]
+				if merged == nil {
+					merged = answerSet{}
+					byResolver[r.Resolver.Name] = merged
+				}
+				for a := range set {
+					merged[a] = true
+				}
+			}
+		} else {
+			// A reference with several addresses counts as one answer
+			for _, r := range results {
+				if set, ok := r.answers[k]; ok && r.matchesReference(ref) {
+					if refSet == nil {
+						refSet = answerSet{}
+					}
+					for a := range set {
+						refSet[a] = true
+					}
+				}
+			}
+			if refSet == nil {
+				continue
+			}
+		}
+
+		for i := range results {
+			r := &results[i]
+			set, ok := r.answers[k]
+			if !ok || (ref != "" && r.matchesReference(ref)) {
+				continue
+			}
+
+			var agreed bool
+			if refSet != nil {
+				agreed = set.overlaps(refSet)
+			} else {
+				peers, votes := 0, 0
+				for name, other := range byResolver {
+					if name == r.Resolver.Name {
+						continue
+					}
+					peers++
+					if set.overlaps(other) {
+						votes++
+					}
+				}
+				if peers == 0 {
+					continue
+				}
+				agreed = votes*2 >= peers
+			}
+
+			c := r.Consistency
+			c.Checked++
+			switch {
+			case agreed:
+				c.Agreed++
+			case len(set) == 0:
+				c.Empty = append(c.Empty, k.String())
+			case set.suspicious():
+				c.Suspicious = append(c.Suspicious, fmt.Sprintf("%s: %s", k, set))
+			default:
+				c.Divergent = append(c.Divergent, fmt.Sprintf("%s: %s", k, set))
+			}
+		}
+	}
+}
@@ -50,3 +50,13 @@ func ExtendedTestDomains() []string {
 		"wikipedia.org", "example.com", "example.org", "example.net",
 	}
 }
+
+// DefaultUncachedZones returns zones with wildcard records, so that any
+// random label beneath them resolves and cannot already be cached
+func DefaultUncachedZones() []string {
+	return []string{
+		"github.io",
+		"blogspot.com",
+		"s3.amazonaws.com",
+	}
+}
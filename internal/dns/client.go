@@ -2,6 +2,9 @@ package dns
 
 import (
 	"context"
+	"fmt"
+	"net/netip"
+	"strings"
 	"time"
 
 	"github.com/miekg/dns"
@@ -10,6 +13,7 @@ import (
 // QueryResult holds the result of a single DNS query
 type QueryResult struct {
 	Resolver     string
+	Time         time.Time // when the query was sent
 	Domain       string
 	QueryType    uint16
 	RTT          time.Duration
@@ -17,41 +21,53 @@ type QueryResult struct {
 	Error        error
 	ResponseCode int
 	AnswerCount  int
+	Answers      []string  // answer data of the queried type, e.g. addresses for A/AAAA
+	Handshake    Handshake // connection setup included in RTT, if any
 }
 
-// Client wraps the miekg/dns client with our configuration
+// ParseQueryType converts a record type name such as "AAAA" or "HTTPS"
+// into its numeric query type
+func ParseQueryType(s string) (uint16, error) {
+	qtype, ok := dns.StringToType[strings.ToUpper(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown query type %q", s)
+	}
+	return qtype, nil
+}
+
+// Client sends queries to a single resolver endpoint over its transport
 type Client struct {
-	client  *dns.Client
-	timeout time.Duration
+	endpoint  Endpoint
+	transport Transport
+	subnet    netip.Prefix
 }
 
-// NewClient creates a new DNS client with specified timeout
-func NewClient(timeout time.Duration, useTCP bool) *Client {
-	c := &dns.Client{
-		Timeout: timeout,
-	}
-	if useTCP {
-		c.Net = "tcp"
-	}
-	return &Client{
-		client:  c,
-		timeout: timeout,
+// NewClient creates a client for ep using the transport its protocol selects
+func NewClient(ep Endpoint, opts Options) (*Client, error) {
+	t, err := NewTransport(ep, opts)
+	if err != nil {
+		return nil, err
 	}
+	return &Client{endpoint: ep, transport: t, subnet: opts.ClientSubnet}, nil
 }
 
 // Query performs a DNS query and returns timing information
-func (c *Client) Query(ctx context.Context, server, domain string, qtype uint16) QueryResult {
+func (c *Client) Query(ctx context.Context, domain string, qtype uint16) QueryResult {
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(domain), qtype)
 	m.RecursionDesired = true
+	if c.subnet.IsValid() {
+		SetClientSubnet(m, c.subnet)
+	}
 
 	result := QueryResult{
-		Resolver:  server,
+		Resolver:  c.endpoint.String(),
+		Time:      time.Now(),
 		Domain:    domain,
 		QueryType: qtype,
 	}
 
-	r, rtt, err := c.client.ExchangeContext(ctx, m, server+":53")
+	r, rtt, handshake, err := c.transport.Exchange(ctx, m)
 	if err != nil {
 		result.Error = err
 		result.Success = false
@@ -59,9 +75,85 @@ func (c *Client) Query(ctx context.Context, server, domain string, qtype uint16)
 	}
 
 	result.RTT = rtt
-	result.Success = r.Rcode == dns.RcodeSuccess
+	// NXDOMAIN is a valid answer from a working resolver, not a failure
+	result.Success = r.Rcode == dns.RcodeSuccess || r.Rcode == dns.RcodeNameError
 	result.ResponseCode = r.Rcode
 	result.AnswerCount = len(r.Answer)
+	result.Handshake = handshake
+	for _, rr := range r.Answer {
+		// CNAMEs leading to the answer are skipped; only the final records are kept
+		if rr.Header().Rrtype == qtype {
+			result.Answers = append(result.Answers, answerData(rr))
+		}
+	}
 
 	return result
 }
+
+// SetClientSubnet adds an EDNS Client Subnet option (RFC 7871) for subnet
+// to m, adding an OPT record if m has none
+func SetClientSubnet(m *dns.Msg, subnet netip.Prefix) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		opt = m.IsEdns0()
+	}
+	subnet = subnet.Masked()
+	ecs := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		SourceNetmask: uint8(subnet.Bits()),
+		Address:       subnet.Addr().AsSlice(),
+	}
+	if subnet.Addr().Is4() {
+		ecs.Family = 1
+	} else {
+		ecs.Family = 2
+	}
+	opt.Option = append(opt.Option, ecs)
+}
+
+// ClientSubnetScope returns the scope prefix length of the ECS option in a
+// response, and false when the response carries none. A scope of 0 means
+// the answer was not tailored to the subnet.
+func ClientSubnetScope(r *dns.Msg) (int, bool) {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return 0, false
+	}
+	for _, o := range opt.Option {
+		if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return int(ecs.SourceScope), true
+		}
+	}
+	return 0, false
+}
+
+// Exchange sends a prepared message and returns the raw response, for probes
+// that need flags or EDNS options Query does not set
+func (c *Client) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	r, rtt, _, err := c.transport.Exchange(ctx, m)
+	return r, rtt, err
+}
+
+// Reconnect forces the next query to establish a new connection
+func (c *Client) Reconnect() {
+	c.transport.Reconnect()
+}
+
+// Close releases any persistent connections held by the client
+func (c *Client) Close() error {
+	return c.transport.Close()
+}
+
+// answerData returns the data of an answer record without its header:
+// the address for A and AAAA records, the presentation format otherwise
+func answerData(rr dns.RR) string {
+	switch rr := rr.(type) {
+	case *dns.A:
+		return rr.A.String()
+	case *dns.AAAA:
+		return rr.AAAA.String()
+	default:
+		return strings.TrimPrefix(rr.String(), rr.Header().String())
+	}
+}
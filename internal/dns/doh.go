@@ -0,0 +1,122 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/http2"
+)
+
+// dohMediaType is the RFC 8484 content type for DNS wire format messages
+const dohMediaType = "application/dns-message"
+
+// DoHTransport sends DNS over HTTPS (RFC 8484) using HTTP/2
+type DoHTransport struct {
+	client   *http.Client
+	endpoint string
+	post     bool
+}
+
+// NewDoHTransport creates a DNS-over-HTTPS transport for the endpoint URL.
+// post selects POST instead of GET requests.
+func NewDoHTransport(endpoint string, post bool, timeout time.Duration) *DoHTransport {
+	return &DoHTransport{
+		client: &http.Client{
+			Transport: &http2.Transport{},
+			Timeout:   timeout,
+		},
+		endpoint: endpoint,
+		post:     post,
+	}
+}
+
+// Exchange sends m and returns the response along with the time spent
+// waiting for it, from when a connection was ready, so TCP and TLS setup are
+// left out as for DoT. When no idle connection could be reused, the setup of
+// the new one is reported as the handshake.
+func (t *DoHTransport) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, time.Duration, Handshake, error) {
+	// RFC 8484 recommends ID 0 so identical queries are HTTP cache friendly
+	m.Id = 0
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, 0, HandshakeNone, fmt.Errorf("pack query: %w", err)
+	}
+
+	var req *http.Request
+	if t.post {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(packed))
+		if err == nil {
+			req.Header.Set("Content-Type", dohMediaType)
+		}
+	} else {
+		var u *url.URL
+		u, err = url.Parse(t.endpoint)
+		if err != nil {
+			return nil, 0, HandshakeNone, fmt.Errorf("parse DoH URL: %w", err)
+		}
+		q := u.Query()
+		q.Set("dns", base64.RawURLEncoding.EncodeToString(packed))
+		u.RawQuery = q.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	}
+	if err != nil {
+		return nil, 0, HandshakeNone, fmt.Errorf("build DoH request: %w", err)
+	}
+	req.Header.Set("Accept", dohMediaType)
+
+	var start time.Time
+	handshake := HandshakeNone
+	req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			start = time.Now()
+			if info.Reused {
+				return
+			}
+			handshake = HandshakeFull
+			if tc, ok := info.Conn.(*tls.Conn); ok && tc.ConnectionState().DidResume {
+				handshake = HandshakeResumed
+			}
+		},
+	}))
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, 0, HandshakeNone, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	rtt := time.Since(start)
+	if err != nil {
+		return nil, 0, HandshakeNone, fmt.Errorf("read DoH response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, HandshakeNone, fmt.Errorf("DoH server returned HTTP %d", resp.StatusCode)
+	}
+
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		return nil, 0, HandshakeNone, fmt.Errorf("unpack DoH response: %w", err)
+	}
+	return r, rtt, handshake, nil
+}
+
+// Reconnect closes idle HTTP/2 connections
+func (t *DoHTransport) Reconnect() {
+	t.client.CloseIdleConnections()
+}
+
+// Close closes idle HTTP/2 connections
+func (t *DoHTransport) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,170 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// doqNoError is the DOQ_NO_ERROR application error code from RFC 9250
+const doqNoError = 0
+
+// DoQTransport sends DNS over QUIC (RFC 9250), one stream per query, on a
+// persistent connection. TLS sessions are cached so reconnects can resume
+// and carry the first query in 0-RTT data.
+type DoQTransport struct {
+	addr     string
+	tlsConf  *tls.Config
+	quicConf *quic.Config
+	timeout  time.Duration
+	idle     idleConn[quic.Connection]
+}
+
+// NewDoQTransport creates a DNS-over-QUIC transport to addr (host:port),
+// verifying the certificate against serverName and keeping up to conns
+// idle connections for concurrent exchanges
+func NewDoQTransport(addr, serverName string, conns int, timeout time.Duration) *DoQTransport {
+	return &DoQTransport{
+		addr: addr,
+		tlsConf: &tls.Config{
+			ServerName:         serverName,
+			NextProtos:         []string{"doq"},
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		},
+		quicConf: &quic.Config{
+			HandshakeIdleTimeout: timeout,
+			MaxIdleTimeout:       30 * time.Second,
+		},
+		timeout: timeout,
+		idle: idleConn[quic.Connection]{
+			size:  conns,
+			close: func(c quic.Connection) { c.CloseWithError(doqNoError, "") },
+		},
+	}
+}
+
+// Exchange sends m on a new stream. When no connection is open the returned
+// duration includes the handshake, and the kind of handshake is reported.
+func (t *DoQTransport) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, time.Duration, Handshake, error) {
+	start := time.Now()
+
+	conn, reused := t.idle.get()
+	if !reused {
+		var err error
+		if conn, err = t.dial(ctx); err != nil {
+			return nil, 0, HandshakeNone, err
+		}
+	}
+
+	r, err := t.stream(ctx, conn, m)
+	if early, ok := conn.(quic.EarlyConnection); ok && errors.Is(err, quic.Err0RTTRejected) {
+		// The server refused early data; the handshake continues as 1-RTT
+		// on a new connection, which replaces the rejected one
+		var next quic.Connection
+		if next, err = early.NextConnection(ctx); err == nil {
+			conn = next
+			r, err = t.stream(ctx, conn, m)
+		}
+	} else if err != nil && reused {
+		// The server may have closed an idle connection; retry once on a fresh one
+		conn.CloseWithError(doqNoError, "")
+		start = time.Now()
+		reused = false
+		if conn, err = t.dial(ctx); err != nil {
+			return nil, 0, HandshakeNone, err
+		}
+		r, err = t.stream(ctx, conn, m)
+	}
+	rtt := time.Since(start)
+	if err != nil {
+		conn.CloseWithError(doqNoError, "")
+		return nil, 0, HandshakeNone, err
+	}
+
+	handshake := HandshakeNone
+	if !reused {
+		if early, ok := conn.(quic.EarlyConnection); ok {
+			select {
+			case <-early.HandshakeComplete():
+			case <-ctx.Done():
+			}
+		}
+		state := conn.ConnectionState()
+		switch {
+		case state.Used0RTT:
+			handshake = Handshake0RTT
+		case state.TLS.DidResume:
+			handshake = HandshakeResumed
+		default:
+			handshake = HandshakeFull
+		}
+	}
+
+	t.idle.put(conn)
+	return r, rtt, handshake, nil
+}
+
+// dial opens a connection that is returned before the handshake completes,
+// so the query can ride in 0-RTT data when a session ticket allows it
+func (t *DoQTransport) dial(ctx context.Context) (quic.Connection, error) {
+	return quic.DialAddrEarly(ctx, t.addr, t.tlsConf, t.quicConf)
+}
+
+// stream performs a single query on a new stream of conn
+func (t *DoQTransport) stream(ctx context.Context, conn quic.Connection, m *dns.Msg) (*dns.Msg, error) {
+	// RFC 9250 requires the message ID to be 0
+	m.Id = 0
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("pack query: %w", err)
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream.SetDeadline(time.Now().Add(t.timeout))
+
+	// Messages carry a 2-octet length prefix, as with DNS over TCP
+	buf := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(buf, uint16(len(packed)))
+	copy(buf[2:], packed)
+	if _, err := stream.Write(buf); err != nil {
+		return nil, err
+	}
+	// Closing the send side signals the end of the query
+	stream.Close()
+
+	var length [2]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(stream, body); err != nil {
+		return nil, err
+	}
+
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		return nil, fmt.Errorf("unpack DoQ response: %w", err)
+	}
+	return r, nil
+}
+
+// Reconnect closes the idle connections; their TLS sessions stay cached
+func (t *DoQTransport) Reconnect() {
+	t.idle.drop()
+}
+
+// Close closes the idle connections
+func (t *DoQTransport) Close() error {
+	t.idle.drop()
+	return nil
+}
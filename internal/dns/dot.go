@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DoTTransport sends DNS over TLS (RFC 7858) on a persistent connection,
+// so the TLS handshake is paid once rather than on every query
+type DoTTransport struct {
+	client *dns.Client
+	dialer *tls.Dialer
+	addr   string
+	idle   idleConn[*dns.Conn]
+}
+
+// NewDoTTransport creates a DNS-over-TLS transport to addr (host:port),
+// verifying the certificate against serverName and keeping up to conns
+// idle connections for concurrent exchanges
+func NewDoTTransport(addr, serverName string, conns int, timeout time.Duration) *DoTTransport {
+	return &DoTTransport{
+		client: &dns.Client{Net: "tcp-tls", Timeout: timeout},
+		dialer: &tls.Dialer{
+			NetDialer: &net.Dialer{Timeout: timeout},
+			Config:    &tls.Config{ServerName: serverName},
+		},
+		addr: addr,
+		idle: idleConn[*dns.Conn]{
+			size:  conns,
+			close: func(c *dns.Conn) { c.Close() },
+		},
+	}
+}
+
+// dial opens a new TLS connection
+func (t *DoTTransport) dial(ctx context.Context) (*dns.Conn, error) {
+	conn, err := t.dialer.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &dns.Conn{Conn: conn}, nil
+}
+
+// Exchange sends m over the persistent connection.
+// Only the exchange itself is timed; connection setup is excluded.
+func (t *DoTTransport) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, time.Duration, Handshake, error) {
+	conn, reused := t.idle.get()
+	if !reused {
+		var err error
+		if conn, err = t.dial(ctx); err != nil {
+			return nil, 0, HandshakeNone, err
+		}
+	}
+
+	r, rtt, err := t.client.ExchangeWithConnContext(ctx, m, conn)
+	if err != nil && reused {
+		// The server may have closed an idle connection; retry once on a fresh one
+		conn.Close()
+		if conn, err = t.dial(ctx); err != nil {
+			return nil, 0, HandshakeNone, err
+		}
+		r, rtt, err = t.client.ExchangeWithConnContext(ctx, m, conn)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, 0, HandshakeNone, err
+	}
+
+	t.idle.put(conn)
+	return r, rtt, HandshakeNone, nil
+}
+
+// Reconnect closes the idle connections
+func (t *DoTTransport) Reconnect() {
+	t.idle.drop()
+}
+
+// Close closes the idle connections
+func (t *DoTTransport) Close() error {
+	t.idle.drop()
+	return nil
+}
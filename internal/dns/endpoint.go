@@ -0,0 +1,177 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Endpoint identifies a resolver and how to reach it
+type Endpoint struct {
+	Protocol   Protocol // empty for a plain endpoint given without a scheme
+	Host       string
+	Port       int    // 0 selects the protocol's default port
+	Path       string // request path, used by DoH
+	ServerName string // TLS server name, used by DoT and DoQ
+}
+
+// schemes maps endpoint URL schemes to the protocol they select
+var schemes = map[string]Protocol{
+	"udp":   ProtoUDP,
+	"tcp":   ProtoTCP,
+	"tls":   ProtoDoT,
+	"https": ProtoDoH,
+	"quic":  ProtoDoQ,
+}
+
+// DefaultPort returns the well-known port for a protocol
+func DefaultPort(p Protocol) int {
+	switch p {
+	case ProtoDoT, ProtoDoQ:
+		return 853
+	case ProtoDoH:
+		return 443
+	default:
+		return 53
+	}
+}
+
+// ParseEndpoint parses a resolver endpoint. Accepted forms include
+// "8.8.8.8", "2001:4860:4860::8888", "[::1]:5353", "tcp://8.8.8.8",
+// "tls://9.9.9.9", "tls://9.9.9.9#dns.quad9.net", "quic://dns.adguard-dns.com"
+// and "https://dns.google/dns-query". For DoT and DoQ the TLS server name
+// follows a '#' and defaults to the host. Without a scheme the protocol is
+// left empty so the caller can choose between UDP and TCP.
+func ParseEndpoint(s string) (Endpoint, error) {
+	var ep Endpoint
+	rest := s
+	if scheme, r, found := strings.Cut(s, "://"); found {
+		proto, ok := schemes[strings.ToLower(scheme)]
+		if !ok {
+			return Endpoint{}, fmt.Errorf("unknown scheme %q in %q", scheme, s)
+		}
+		ep.Protocol, rest = proto, r
+	}
+
+	if ep.Protocol == ProtoDoH {
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			return Endpoint{}, fmt.Errorf("invalid DoH URL %q", s)
+		}
+		ep.Host = u.Hostname()
+		if ep.Port, err = parsePort(u.Port()); err != nil {
+			return Endpoint{}, fmt.Errorf("invalid port in %q: %w", s, err)
+		}
+		ep.Path = u.RequestURI()
+		return ep, nil
+	}
+
+	hostport, name, _ := strings.Cut(rest, "#")
+	host, port, err := splitHostPort(hostport)
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("invalid endpoint %q: %w", s, err)
+	}
+	ep.Host = host
+	if ep.Port, err = parsePort(port); err != nil {
+		return Endpoint{}, fmt.Errorf("invalid port in %q: %w", s, err)
+	}
+	if ep.Protocol == ProtoDoT || ep.Protocol == ProtoDoQ {
+		ep.ServerName = name
+		if ep.ServerName == "" {
+			ep.ServerName = host
+		}
+	} else if name != "" {
+		return Endpoint{}, fmt.Errorf("TLS server name given for plain endpoint %q", s)
+	}
+	return ep, nil
+}
+
+// splitHostPort separates an optional port from host, accepting bare IPv6
+// addresses as well as the bracketed form
+func splitHostPort(s string) (host, port string, err error) {
+	if s == "" {
+		return "", "", fmt.Errorf("missing host")
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return s, "", nil
+	}
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		return s[1 : len(s)-1], "", nil
+	}
+	if !strings.Contains(s, ":") {
+		return s, "", nil
+	}
+	return net.SplitHostPort(s)
+}
+
+// parsePort converts a port string, returning 0 when it is empty
+func parsePort(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %q out of range", s)
+	}
+	return port, nil
+}
+
+// port returns the explicit port or the protocol default
+func (e Endpoint) port() int {
+	if e.Port != 0 {
+		return e.Port
+	}
+	return DefaultPort(e.Protocol)
+}
+
+// Address returns the host:port string to dial
+func (e Endpoint) Address() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.port()))
+}
+
+// URL returns the https URL of a DoH endpoint
+func (e Endpoint) URL() string {
+	host := e.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if e.Port != 0 && e.Port != DefaultPort(ProtoDoH) {
+		host = net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	}
+	return "https://" + host + e.Path
+}
+
+// String returns the endpoint in the form ParseEndpoint accepts. Plain UDP
+// endpoints on port 53 are shown as the bare host.
+func (e Endpoint) String() string {
+	if e.Protocol == ProtoDoH {
+		return e.URL()
+	}
+
+	s := e.Host
+	if e.Port != 0 && e.Port != DefaultPort(e.Protocol) {
+		s = net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	} else if strings.Contains(e.Host, ":") && e.Protocol != "" && e.Protocol != ProtoUDP {
+		s = "[" + e.Host + "]"
+	}
+
+	switch e.Protocol {
+	case ProtoTCP:
+		s = "tcp://" + s
+	case ProtoDoT:
+		s = "tls://" + s
+	case ProtoDoQ:
+		s = "quic://" + s
+	}
+	if e.ServerName != "" && e.ServerName != e.Host {
+		s += "#" + e.ServerName
+	}
+	return s
+}
+
+// MarshalText encodes the endpoint as its string form
+func (e Endpoint) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
@@ -0,0 +1,67 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Error classes group query failures by cause for reporting
+const (
+	ErrorTimeout  = "timeout"  // no response before the deadline
+	ErrorRefused  = "refused"  // connection refused or port unreachable
+	ErrorNetwork  = "network"  // other socket or connection errors
+	ErrorTLS      = "tls"      // TLS handshake or certificate errors
+	ErrorCanceled = "canceled" // the run was stopped
+	ErrorRcode    = "rcode"    // a response with a failure code such as SERVFAIL
+	ErrorOther    = "other"
+)
+
+// ErrorClass returns the class of a failed query, or "" if it succeeded
+func (r QueryResult) ErrorClass() string {
+	if r.Error == nil {
+		if r.Success {
+			return ""
+		}
+		return ErrorRcode
+	}
+	return classify(r.Error)
+}
+
+// classify maps a transport error to its class
+func classify(err error) string {
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	var headerErr tls.RecordHeaderError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var transportErr *quic.TransportError
+	var idleErr *quic.IdleTimeoutError
+	var handshakeTimeout *quic.HandshakeTimeoutError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrorCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &idleErr), errors.As(err, &handshakeTimeout):
+		return ErrorTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorRefused
+	case errors.As(err, &certErr), errors.As(err, &alertErr), errors.As(err, &headerErr),
+		errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr):
+		return ErrorTLS
+	case errors.As(err, &transportErr) && transportErr.ErrorCode.IsCryptoError():
+		return ErrorTLS
+	case errors.As(err, &netErr), errors.As(err, &transportErr):
+		return ErrorNetwork
+	default:
+		return ErrorOther
+	}
+}
@@ -0,0 +1,14 @@
+package dns
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// RandomLabel returns a unique DNS label, so that a name built from it
+// cannot be in any resolver's cache
+func RandomLabel() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "speeddns-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,193 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// muxBatchSize is the most packets sent or received in one system call
+const muxBatchSize = 64
+
+// errMuxClosed is returned for exchanges on a closed MuxTransport
+var errMuxClosed = errors.New("multiplexed transport closed")
+
+// MuxTransport sends DNS over UDP on a small pool of long-lived sockets,
+// with many queries outstanding on each. Replies are matched to queries by
+// message ID and question, so no socket is opened per query and the
+// measured RTT is the network exchange alone. On Linux, packets are sent
+// and received in batches with sendmmsg/recvmmsg.
+type MuxTransport struct {
+	conns   []*muxConn
+	next    atomic.Uint32
+	timeout time.Duration
+}
+
+// muxKey identifies an outstanding query on a socket
+type muxKey struct {
+	id     uint16
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+// muxReply is a response along with the time it was received
+type muxReply struct {
+	msg *dns.Msg
+	at  time.Time
+}
+
+// muxConn is one socket of a MuxTransport with its outstanding queries
+type muxConn struct {
+	conn      *net.UDPConn
+	sendq     chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	pending map[muxKey]chan muxReply
+}
+
+// NewMuxTransport creates a multiplexed UDP transport to addr (host:port)
+// using the given number of sockets
+func NewMuxTransport(addr string, sockets int, timeout time.Duration) (*MuxTransport, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &MuxTransport{timeout: timeout}
+	for i := 0; i < sockets; i++ {
+		conn, err := net.DialUDP("udp", nil, raddr)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		c := &muxConn{
+			conn:    conn,
+			sendq:   make(chan []byte, muxBatchSize*4),
+			done:    make(chan struct{}),
+			pending: make(map[muxKey]chan muxReply),
+		}
+		go c.readLoop()
+		go c.writeLoop()
+		t.conns = append(t.conns, c)
+	}
+	return t, nil
+}
+
+// newMuxKey builds the key matching m; names compare case-insensitively
+func newMuxKey(m *dns.Msg) muxKey {
+	key := muxKey{id: m.Id}
+	if len(m.Question) > 0 {
+		q := m.Question[0]
+		key.name = strings.ToLower(q.Name)
+		key.qtype = q.Qtype
+		key.qclass = q.Qclass
+	}
+	return key
+}
+
+// Exchange sends m on the next socket and waits for the matching reply.
+// The returned duration runs from queueing the query to receiving the reply.
+func (t *MuxTransport) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, time.Duration, Handshake, error) {
+	c := t.conns[int(t.next.Add(1))%len(t.conns)]
+
+	ch := make(chan muxReply, 1)
+	key, err := c.register(m, ch)
+	if err != nil {
+		return nil, 0, HandshakeNone, err
+	}
+	defer c.unregister(key)
+
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, 0, HandshakeNone, fmt.Errorf("pack query: %w", err)
+	}
+
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+
+	start := time.Now()
+	select {
+	case c.sendq <- packed:
+	case <-c.done:
+		return nil, 0, HandshakeNone, errMuxClosed
+	}
+
+	select {
+	case r := <-ch:
+		return r.msg, r.at.Sub(start), HandshakeNone, nil
+	case <-timer.C:
+		return nil, 0, HandshakeNone, fmt.Errorf("read udp %s: %w", c.conn.RemoteAddr(), os.ErrDeadlineExceeded)
+	case <-ctx.Done():
+		return nil, 0, HandshakeNone, ctx.Err()
+	case <-c.done:
+		return nil, 0, HandshakeNone, errMuxClosed
+	}
+}
+
+// register assigns m an ID not outstanding for the same question and
+// records ch as the receiver of its reply
+func (c *muxConn) register(m *dns.Msg, ch chan muxReply) (muxKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for tries := 0; tries < 100; tries++ {
+		m.Id = uint16(rand.Uint32())
+		key := newMuxKey(m)
+		if _, busy := c.pending[key]; !busy {
+			c.pending[key] = ch
+			return key, nil
+		}
+	}
+	return muxKey{}, errors.New("no free message ID")
+}
+
+// unregister forgets an outstanding query
+func (c *muxConn) unregister(key muxKey) {
+	c.mu.Lock()
+	delete(c.pending, key)
+	c.mu.Unlock()
+}
+
+// deliver hands a received packet to the query waiting for it. Packets
+// that match no outstanding query, such as replies arriving after the
+// timeout, are dropped.
+func (c *muxConn) deliver(packet []byte, at time.Time) {
+	r := new(dns.Msg)
+	if err := r.Unpack(packet); err != nil {
+		return
+	}
+	key := newMuxKey(r)
+
+	c.mu.Lock()
+	ch, ok := c.pending[key]
+	delete(c.pending, key)
+	c.mu.Unlock()
+	if ok {
+		ch <- muxReply{msg: r, at: at}
+	}
+}
+
+// Reconnect does nothing: the sockets are connectionless
+func (t *MuxTransport) Reconnect() {}
+
+// Close closes every socket, failing outstanding exchanges
+func (t *MuxTransport) Close() error {
+	for _, c := range t.conns {
+		c.closeOnce.Do(func() {
+			close(c.done)
+			c.conn.Close()
+		})
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+//go:build linux
+
+package dns
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// batchConn sends and receives several packets per system call. ipv4 and
+// ipv6 messages are the same type, so either packet conn satisfies it.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// batch wraps the socket in the packet conn for its address family
+func (c *muxConn) batch() batchConn {
+	if addr, ok := c.conn.RemoteAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		return ipv6.NewPacketConn(c.conn)
+	}
+	return ipv4.NewPacketConn(c.conn)
+}
+
+// readLoop receives replies with recvmmsg until the socket is closed
+func (c *muxConn) readLoop() {
+	bc := c.batch()
+	msgs := make([]ipv4.Message, muxBatchSize)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, 65535)}
+	}
+	for {
+		n, err := bc.ReadBatch(msgs, 0)
+		at := time.Now()
+		if err != nil {
+			select {
+			case <-c.done:
+				return
+			default:
+				// ICMP errors such as port unreachable surface here; the
+				// affected queries time out
+				continue
+			}
+		}
+		for _, m := range msgs[:n] {
+			c.deliver(m.Buffers[0][:m.N], at)
+		}
+	}
+}
+
+// writeLoop sends queued queries with sendmmsg, as many per call as are
+// waiting, until the socket is closed
+func (c *muxConn) writeLoop() {
+	bc := c.batch()
+	msgs := make([]ipv4.Message, muxBatchSize)
+	for {
+		var packet []byte
+		select {
+		case packet = <-c.sendq:
+		case <-c.done:
+			return
+		}
+		msgs[0].Buffers = [][]byte{packet}
+		n := 1
+	drain:
+		for n < muxBatchSize {
+			select {
+			case packet = <-c.sendq:
+				msgs[n].Buffers = [][]byte{packet}
+				n++
+			default:
+				break drain
+			}
+		}
+		for sent := 0; sent < n; {
+			k, err := bc.WriteBatch(msgs[sent:n], 0)
+			if err != nil {
+				// Unsent queries time out
+				break
+			}
+			sent += k
+		}
+	}
+}
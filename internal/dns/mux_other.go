@@ -0,0 +1,36 @@
+//go:build !linux
+
+package dns
+
+import "time"
+
+// readLoop receives replies one at a time until the socket is closed
+func (c *muxConn) readLoop() {
+	buf := make([]byte, 65535)
+	for {
+		n, err := c.conn.Read(buf)
+		at := time.Now()
+		if err != nil {
+			select {
+			case <-c.done:
+				return
+			default:
+				continue
+			}
+		}
+		c.deliver(buf[:n], at)
+	}
+}
+
+// writeLoop sends queued queries one at a time until the socket is closed
+func (c *muxConn) writeLoop() {
+	for {
+		select {
+		case packet := <-c.sendq:
+			// Unsent queries time out
+			c.conn.Write(packet)
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package dns
+
+import "sync"
+
+// idleConn holds idle connections so that session-based transports pay
+// their handshake once per connection rather than once per query.
+// Concurrent exchanges each take their own connection; up to size of them
+// are kept when returned and the surplus is closed.
+type idleConn[C any] struct {
+	mu    sync.Mutex
+	conns []C
+	size  int // connections kept, at least 1
+	close func(C)
+}
+
+// get removes and returns an idle connection, if any
+func (p *idleConn[C]) get() (C, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var zero C
+	n := len(p.conns)
+	if n == 0 {
+		return zero, false
+	}
+	conn := p.conns[n-1]
+	p.conns[n-1] = zero
+	p.conns = p.conns[:n-1]
+	return conn, true
+}
+
+// put stores conn as an idle connection, closing it if the pool is full
+func (p *idleConn[C]) put(conn C) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.conns) >= max(p.size, 1) {
+		p.close(conn)
+		return
+	}
+	p.conns = append(p.conns, conn)
+}
+
+// drop closes the idle connections
+func (p *idleConn[C]) drop() {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = nil
+	p.mu.Unlock()
+	for _, conn := range conns {
+		p.close(conn)
+	}
+}
@@ -0,0 +1,132 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Protocol identifies the transport used to reach a resolver
+type Protocol string
+
+const (
+	ProtoUDP Protocol = "udp"
+	ProtoTCP Protocol = "tcp"
+	ProtoDoT Protocol = "dot"
+	ProtoDoH Protocol = "doh"
+	ProtoDoQ Protocol = "doq"
+)
+
+// ParseProtocol converts a user supplied name into a Protocol
+func ParseProtocol(s string) (Protocol, error) {
+	switch p := Protocol(s); p {
+	case ProtoUDP, ProtoTCP, ProtoDoT, ProtoDoH, ProtoDoQ:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown protocol %q (want udp, tcp, dot, doh or doq)", s)
+	}
+}
+
+// Handshake describes the connection setup an exchange had to perform
+type Handshake string
+
+const (
+	HandshakeNone    Handshake = ""
+	HandshakeFull    Handshake = "full"
+	HandshakeResumed Handshake = "resumed"
+	Handshake0RTT    Handshake = "0-rtt"
+)
+
+// Transport exchanges DNS messages with a single resolver endpoint
+type Transport interface {
+	// Exchange sends m and waits for the response. The returned duration is
+	// the time spent on the exchange, and Handshake reports any connection
+	// setup it performed. DoQ includes the handshake in the duration, since
+	// it carries the query; other transports leave setup out.
+	Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, time.Duration, Handshake, error)
+
+	// Reconnect drops any persistent connection so the next exchange has to
+	// establish a new one
+	Reconnect()
+
+	// Close releases any persistent connections
+	Close() error
+}
+
+// Options configures clients and the transports they create
+type Options struct {
+	Timeout time.Duration
+	DoHPost bool // send DoH queries with POST instead of GET
+
+	// MuxSockets, when set, sends UDP queries over a multiplexed pool of
+	// this many long-lived sockets instead of a new socket per query
+	MuxSockets int
+
+	// IdleConns is the number of idle DoT and DoQ connections kept for
+	// reuse, so concurrent queries do not each need a handshake. Zero
+	// keeps one.
+	IdleConns int
+
+	// ClientSubnet is sent as an EDNS Client Subnet option with every
+	// query when set
+	ClientSubnet netip.Prefix
+}
+
+// NewTransport creates the transport matching ep.Protocol. An endpoint
+// without a protocol is reached over UDP.
+func NewTransport(ep Endpoint, opts Options) (Transport, error) {
+	switch ep.Protocol {
+	case ProtoUDP, "":
+		if opts.MuxSockets > 0 {
+			return NewMuxTransport(ep.Address(), opts.MuxSockets, opts.Timeout)
+		}
+		return NewUDPTransport(ep.Address(), opts.Timeout), nil
+	case ProtoTCP:
+		return NewTCPTransport(ep.Address(), opts.Timeout), nil
+	case ProtoDoT:
+		return NewDoTTransport(ep.Address(), ep.ServerName, opts.IdleConns, opts.Timeout), nil
+	case ProtoDoH:
+		return NewDoHTransport(ep.URL(), opts.DoHPost, opts.Timeout), nil
+	case ProtoDoQ:
+		return NewDoQTransport(ep.Address(), ep.ServerName, opts.IdleConns, opts.Timeout), nil
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", ep.Protocol)
+	}
+}
+
+// PlainTransport sends unencrypted DNS over UDP or TCP
+type PlainTransport struct {
+	client *dns.Client
+	addr   string
+}
+
+// NewUDPTransport creates a transport for plain DNS over UDP to addr (host:port)
+func NewUDPTransport(addr string, timeout time.Duration) *PlainTransport {
+	return &PlainTransport{
+		client: &dns.Client{Timeout: timeout},
+		addr:   addr,
+	}
+}
+
+// NewTCPTransport creates a transport for plain DNS over TCP to addr (host:port)
+func NewTCPTransport(addr string, timeout time.Duration) *PlainTransport {
+	return &PlainTransport{
+		client: &dns.Client{Net: "tcp", Timeout: timeout},
+		addr:   addr,
+	}
+}
+
+// Exchange sends m on a fresh socket
+func (t *PlainTransport) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, time.Duration, Handshake, error) {
+	r, rtt, err := t.client.ExchangeContext(ctx, m, t.addr)
+	return r, rtt, HandshakeNone, err
+}
+
+// Reconnect is a no-op; every exchange uses a new socket
+func (t *PlainTransport) Reconnect() {}
+
+// Close is a no-op; no connections are kept open
+func (t *PlainTransport) Close() error { return nil }
@@ -0,0 +1,277 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"speeddns/internal/dns"
+	"speeddns/internal/stats"
+)
+
+// Config holds load test configuration. The rate starts at StartQPS and
+// rises by StepQPS after every StepDuration until EndQPS; a StepQPS of 0,
+// or an EndQPS not above StartQPS, runs a single step at a fixed rate.
+type Config struct {
+	StartQPS     int
+	EndQPS       int
+	StepQPS      int
+	StepDuration time.Duration
+
+	Domains   []string
+	QueryType uint16
+	Options   dns.Options
+
+	// MaxInflight caps outstanding queries. Sends that would exceed it are
+	// counted as dropped rather than delayed, so the load stays open-loop.
+	// DoT and DoQ keep up to this many connections open for reuse.
+	MaxInflight int
+
+	// A step is saturated when its loss rate exceeds MaxLoss (a fraction)
+	// or its corrected p99 latency exceeds MaxP99. Zero disables a check.
+	// The ramp stops at the first saturated step.
+	MaxLoss float64
+	MaxP99  time.Duration
+}
+
+// DefaultConfig returns sensible defaults
+func DefaultConfig() Config {
+	return Config{
+		StartQPS:     100,
+		StepDuration: 10 * time.Second,
+		MaxInflight:  10000,
+		MaxLoss:      0.01,
+		MaxP99:       100 * time.Millisecond,
+	}
+}
+
+// Step holds the outcome of sending at one rate
+type Step struct {
+	TargetQPS   int     `json:"target_qps"`
+	AchievedQPS float64 `json:"achieved_qps"`
+	Sent        int     `json:"sent"`
+	Answered    int     `json:"answered"`
+	// Lost counts queries that got no response before the timeout
+	Lost int `json:"lost"`
+	// Errors counts responses with an error code such as SERVFAIL
+	Errors int `json:"errors"`
+	// Dropped counts sends skipped because MaxInflight was reached. They
+	// were never sent, so they are not part of the loss rate.
+	Dropped int `json:"dropped"`
+
+	// Latency is measured from when each query was scheduled to be sent,
+	// correcting for coordinated omission when the sender falls behind.
+	// ServiceTime is measured from when it was actually sent.
+	Latency     stats.Summary `json:"latency"`
+	ServiceTime stats.Summary `json:"service_time"`
+
+	Saturated bool   `json:"saturated"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// LossRate returns the fraction of sent queries that got no response
+func (s Step) LossRate() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Lost) / float64(s.Sent)
+}
+
+// Report holds the load test results for one resolver
+type Report struct {
+	Target dns.Endpoint `json:"target"`
+	Steps  []Step       `json:"steps"`
+	// SaturationQPS is the highest rate that stayed within the thresholds,
+	// 0 when even the first step was saturated
+	SaturationQPS int `json:"saturation_qps"`
+	// Saturated reports whether a step exceeded a threshold
+	Saturated bool   `json:"saturated"`
+	Error     string `json:"error,omitempty"`
+	// Partial reports that the test was interrupted before the ramp
+	// finished; the step in progress is left out
+	Partial bool `json:"partial,omitempty"`
+}
+
+// sample is the outcome of a single query
+type sample struct {
+	latency     time.Duration
+	serviceTime time.Duration
+	answered    bool
+	failed      bool
+}
+
+// Runner drives open-loop load against resolvers
+type Runner struct {
+	config Config
+}
+
+// New creates a new Runner, checking that the configuration can send
+func New(config Config) (*Runner, error) {
+	switch {
+	case config.StartQPS < 1:
+		return nil, fmt.Errorf("the query rate must be at least 1")
+	case config.StepDuration <= 0:
+		return nil, fmt.Errorf("the step duration must be positive")
+	case config.MaxInflight < 1:
+		return nil, fmt.Errorf("the in-flight limit must be at least 1")
+	case len(config.Domains) == 0:
+		return nil, fmt.Errorf("no domains to query")
+	}
+	return &Runner{config: config}, nil
+}
+
+// rates returns the QPS of every step
+func (r *Runner) rates() []int {
+	c := r.config
+	if c.StepQPS <= 0 || c.EndQPS <= c.StartQPS {
+		return []int{c.StartQPS}
+	}
+	var rates []int
+	for qps := c.StartQPS; qps <= c.EndQPS; qps += c.StepQPS {
+		rates = append(rates, qps)
+	}
+	return rates
+}
+
+// Run load tests each target in turn, so resolvers do not compete for the
+// sender's capacity. progress, if set, is called after every step.
+func (r *Runner) Run(ctx context.Context, targets []dns.Endpoint, progress func(dns.Endpoint, Step)) []Report {
+	var reports []Report
+	for _, t := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+		reports = append(reports, r.runTarget(ctx, t, progress))
+	}
+	return reports
+}
+
+// runTarget ramps the rate against a single resolver until it saturates
+func (r *Runner) runTarget(ctx context.Context, target dns.Endpoint, progress func(dns.Endpoint, Step)) Report {
+	report := Report{Target: target}
+
+	// Every concurrent query takes its own DoT or DoQ connection; keeping
+	// them all open stops the test from measuring handshake throughput
+	opts := r.config.Options
+	opts.IdleConns = r.config.MaxInflight
+	client, err := dns.NewClient(target, opts)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	defer client.Close()
+
+	for _, qps := range r.rates() {
+		step := r.runStep(ctx, client, qps)
+		if ctx.Err() != nil {
+			// The step was cut short, so its rate and loss mean nothing
+			report.Partial = true
+			break
+		}
+		r.checkThresholds(&step)
+		report.Steps = append(report.Steps, step)
+		if progress != nil {
+			progress(target, step)
+		}
+		if step.Saturated {
+			report.Saturated = true
+			break
+		}
+		report.SaturationQPS = qps
+	}
+	return report
+}
+
+// runStep sends at qps for one step duration. Each query is sent on
+// schedule in its own goroutine, whether or not earlier ones were answered.
+func (r *Runner) runStep(ctx context.Context, client *dns.Client, qps int) Step {
+	step := Step{TargetQPS: qps}
+	n := int(r.config.StepDuration.Seconds() * float64(qps))
+	if n < 1 {
+		n = 1
+	}
+	interval := float64(time.Second) / float64(qps)
+
+	samples := make([]sample, n)
+	sent := make([]bool, n)
+	inflight := make(chan struct{}, r.config.MaxInflight)
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	var last time.Time
+	for i := 0; i < n; i++ {
+		intended := start.Add(time.Duration(float64(i) * interval))
+		if d := time.Until(intended); d > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(d):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case inflight <- struct{}{}:
+		default:
+			step.Dropped++
+			continue
+		}
+
+		sent[i] = true
+		last = time.Now()
+		domain := r.config.Domains[i%len(r.config.Domains)]
+		wg.Add(1)
+		go func(i int, intended time.Time) {
+			defer wg.Done()
+			defer func() { <-inflight }()
+
+			qr := client.Query(ctx, domain, r.config.QueryType)
+			done := time.Now()
+			samples[i] = sample{
+				latency:     done.Sub(intended),
+				serviceTime: qr.RTT,
+				answered:    qr.Error == nil,
+				failed:      qr.Error == nil && !qr.Success,
+			}
+		}(i, intended)
+	}
+	wg.Wait()
+
+	var latencies, serviceTimes []time.Duration
+	for i, s := range samples {
+		if !sent[i] {
+			continue
+		}
+		step.Sent++
+		switch {
+		case !s.answered:
+			step.Lost++
+		case s.failed:
+			step.Errors++
+		default:
+			step.Answered++
+			latencies = append(latencies, s.latency)
+			serviceTimes = append(serviceTimes, s.serviceTime)
+		}
+	}
+	if elapsed := last.Sub(start); elapsed > 0 && step.Sent > 1 {
+		step.AchievedQPS = float64(step.Sent-1) / elapsed.Seconds()
+	}
+	step.Latency = stats.Calculate(latencies)
+	step.ServiceTime = stats.Calculate(serviceTimes)
+	return step
+}
+
+// checkThresholds marks the step saturated when it exceeded a threshold
+func (r *Runner) checkThresholds(step *Step) {
+	switch {
+	case r.config.MaxLoss > 0 && step.LossRate() > r.config.MaxLoss:
+		step.Saturated = true
+		step.Reason = fmt.Sprintf("loss %.2f%% over %.2f%%", step.LossRate()*100, r.config.MaxLoss*100)
+	case r.config.MaxP99 > 0 && step.Latency.P99 > r.config.MaxP99:
+		step.Saturated = true
+		step.Reason = fmt.Sprintf("p99 %s over %s", step.Latency.P99.Round(time.Microsecond), r.config.MaxP99)
+	}
+}
@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"speeddns/internal/benchmark"
+	"speeddns/internal/dns"
+	"speeddns/internal/monitor"
+
+	mdns "github.com/miekg/dns"
+)
+
+// labels identify the resolver address a metric belongs to
+var labels = []string{"name", "provider", "address", "transport"}
+
+// latencyBuckets span cache hits on a LAN to slow recursive lookups, in
+// seconds
+var latencyBuckets = []float64{
+	0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5,
+}
+
+// Collector exports monitor results as Prometheus metrics. Query counts and
+// latencies are recorded as queries complete; availability is read from the
+// monitor's rolling windows when scraped.
+type Collector struct {
+	registry  *prometheus.Registry
+	latency   *prometheus.HistogramVec
+	responses *prometheus.CounterVec
+	errors    *prometheus.CounterVec
+
+	availability *prometheus.Desc
+
+	mu     sync.Mutex
+	status func() []monitor.Status
+}
+
+// New creates a Collector with its own registry
+func New() *Collector {
+	c := &Collector{
+		registry: prometheus.NewRegistry(),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "speeddns_query_duration_seconds",
+			Help:    "Round-trip time of successful queries, excluding connection setup.",
+			Buckets: latencyBuckets,
+		}, labels),
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "speeddns_responses_total",
+			Help: "Responses received, by response code.",
+		}, append(labels, "rcode")),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "speeddns_query_errors_total",
+			Help: "Failed queries, by error class; rcode counts responses with a failure code.",
+		}, append(labels, "class")),
+		availability: prometheus.NewDesc(
+			"speeddns_availability_ratio",
+			"Fraction of queries that succeeded over the trailing window.",
+			append(labels, "window"), nil,
+		),
+	}
+	c.registry.MustRegister(c.latency, c.responses, c.errors, c)
+	return c
+}
+
+// Observe records a completed query, as a monitor.Config OnQuery hook
+func (c *Collector) Observe(t benchmark.Target, qr dns.QueryResult) {
+	values := labelValues(t.Resolver.Name, t.Resolver.Provider, t.Endpoint)
+
+	if class := qr.ErrorClass(); class != "" {
+		c.errors.WithLabelValues(append(values, class)...).Inc()
+	}
+	if qr.Error != nil {
+		return
+	}
+	c.responses.WithLabelValues(append(values, mdns.RcodeToString[qr.ResponseCode])...).Inc()
+	if qr.Success && qr.Handshake == dns.HandshakeNone {
+		c.latency.WithLabelValues(values...).Observe(qr.RTT.Seconds())
+	}
+}
+
+// SetStatus sets the source of the availability gauges, normally a
+// monitor's Snapshot method
+func (c *Collector) SetStatus(status func() []monitor.Status) {
+	c.mu.Lock()
+	c.status = status
+	c.mu.Unlock()
+}
+
+// Describe implements prometheus.Collector for the availability gauges
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.availability
+}
+
+// Collect implements prometheus.Collector for the availability gauges.
+// Windows without queries are left out rather than reported as 0.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	status := c.status
+	c.mu.Unlock()
+	if status == nil {
+		return
+	}
+
+	for _, s := range status() {
+		values := labelValues(s.Resolver.Name, s.Resolver.Provider, s.Address)
+		for _, w := range s.Windows {
+			if w.Queries == 0 {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(c.availability, prometheus.GaugeValue,
+				w.Availability(), append(values, monitor.FormatSpan(w.Span))...)
+		}
+	}
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (c *Collector) Handler() http.Handler {
+	return promhttp.HandlerFor(c.registry, promhttp.HandlerOpts{})
+}
+
+// labelValues returns the values for labels
+func labelValues(name, provider string, ep dns.Endpoint) []string {
+	return []string{name, provider, ep.String(), string(ep.Protocol)}
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"speeddns/internal/benchmark"
+	"speeddns/internal/probe"
+)
+
+// Descriptions of the metrics for a one-shot run. They are all gauges or
+// histograms, whose samples are named the same in the Prometheus text and
+// OpenMetrics formats, so either parser accepts the output.
+var (
+	runTimestampDesc = prometheus.NewDesc(
+		"speeddns_last_run_timestamp_seconds",
+		"Time the benchmark run finished.",
+		nil, nil,
+	)
+	durationDesc = prometheus.NewDesc(
+		"speeddns_query_duration_seconds",
+		"Round-trip time of successful queries, excluding connection setup.",
+		labels, nil,
+	)
+	queriesDesc = prometheus.NewDesc(
+		"speeddns_queries",
+		"Queries sent in the run.",
+		labels, nil,
+	)
+	failuresDesc = prometheus.NewDesc(
+		"speeddns_query_failures",
+		"Queries in the run that failed or got no response.",
+		labels, nil,
+	)
+	successDesc = prometheus.NewDesc(
+		"speeddns_success_ratio",
+		"Fraction of queries in the run that succeeded.",
+		labels, nil,
+	)
+	consistentDesc = prometheus.NewDesc(
+		"speeddns_answers_consistent",
+		"1 if the resolver's answers agreed with the other resolvers', when checked.",
+		labels, nil,
+	)
+	nxdomainDesc = prometheus.NewDesc(
+		"speeddns_nxdomain_hijacked",
+		"1 if the resolver answered nonexistent names with an address, when checked.",
+		labels, nil,
+	)
+	dnssecDesc = prometheus.NewDesc(
+		"speeddns_dnssec_validating",
+		"1 if the resolver validated DNSSEC, when probed successfully.",
+		labels, nil,
+	)
+)
+
+// resultsCollector exposes the results of a finished run
+type resultsCollector struct {
+	results []benchmark.ResolverResult
+	at      time.Time
+}
+
+// Results returns a registry holding metrics for the results of a one-shot
+// run that finished at the given time
+func Results(results []benchmark.ResolverResult, at time.Time) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&resultsCollector{results: results, at: at})
+	return registry
+}
+
+// Describe implements prometheus.Collector
+func (c *resultsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		runTimestampDesc, durationDesc, queriesDesc, failuresDesc, successDesc,
+		consistentDesc, nxdomainDesc, dnssecDesc,
+	} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector. Probe metrics are only present
+// for resolvers that were probed.
+func (c *resultsCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(runTimestampDesc, prometheus.GaugeValue, float64(c.at.UnixMilli())/1000)
+
+	for _, r := range c.results {
+		values := labelValues(r.Resolver.Name, r.Resolver.Provider, r.Address)
+
+		ch <- prometheus.MustNewConstMetric(queriesDesc, prometheus.GaugeValue, float64(r.Queries), values...)
+		ch <- prometheus.MustNewConstMetric(failuresDesc, prometheus.GaugeValue, float64(r.Failures), values...)
+		if r.Queries > 0 {
+			ch <- prometheus.MustNewConstMetric(successDesc, prometheus.GaugeValue,
+				float64(r.Successes)/float64(r.Queries), values...)
+		}
+
+		var sum float64
+		buckets := make(map[float64]uint64, len(latencyBuckets))
+		for _, rtt := range r.RTTs {
+			s := rtt.Seconds()
+			sum += s
+			for _, b := range latencyBuckets {
+				if s <= b {
+					buckets[b]++
+				}
+			}
+		}
+		ch <- prometheus.MustNewConstHistogram(durationDesc, uint64(len(r.RTTs)), sum, buckets, values...)
+
+		if r.Consistency != nil {
+			ch <- prometheus.MustNewConstMetric(consistentDesc, prometheus.GaugeValue, boolValue(r.Consistency.OK()), values...)
+		}
+		if r.NXDomain != nil {
+			ch <- prometheus.MustNewConstMetric(nxdomainDesc, prometheus.GaugeValue, boolValue(!r.NXDomain.OK()), values...)
+		}
+		if r.DNSSEC != nil && r.DNSSEC.Status() != probe.DNSSECUnknown {
+			ch <- prometheus.MustNewConstMetric(dnssecDesc, prometheus.GaugeValue, boolValue(r.DNSSEC.Validates()), values...)
+		}
+	}
+}
+
+// boolValue converts a flag to a gauge value
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,295 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"speeddns/internal/benchmark"
+	"speeddns/internal/dns"
+	"speeddns/internal/resolver"
+	"speeddns/internal/stats"
+)
+
+// Config holds monitor configuration
+type Config struct {
+	// Benchmark configures the queries and the resolver addresses to test
+	Benchmark benchmark.Config
+
+	// Interval is the time between the starts of scheduled benchmark runs
+	Interval time.Duration
+
+	// Rate, when set, replaces scheduled runs with a steady stream of this
+	// many queries per second to every address, cycling through the domains
+	Rate float64
+
+	// Windows are the trailing periods statistics are reported over, from
+	// shortest to longest. The longest decides how long outcomes are kept.
+	Windows []time.Duration
+
+	// OnQuery, if set, is called with every query as it completes, in both
+	// modes. Calls for different addresses are made concurrently.
+	OnQuery func(benchmark.Target, dns.QueryResult)
+}
+
+// MaxRate is the highest per-address query rate of the stream mode. Above
+// it the ticker period would round towards zero, and the monitor would be
+// a load test; use the load command for that.
+const MaxRate = 1000
+
+// DefaultConfig returns sensible defaults
+func DefaultConfig() Config {
+	return Config{
+		Benchmark: benchmark.DefaultConfig(),
+		Interval:  time.Minute,
+		Windows:   []time.Duration{5 * time.Minute, 15 * time.Minute, time.Hour},
+	}
+}
+
+// Status holds the rolling statistics of one resolver address
+type Status struct {
+	Resolver resolver.Resolver     `json:"resolver"`
+	Address  dns.Endpoint          `json:"address"`
+	Protocol dns.Protocol          `json:"protocol"`
+	Windows  []stats.WindowSummary `json:"windows"`
+	// LastError is the most recent query error, cleared by a success
+	LastError string    `json:"last_error,omitempty"`
+	LastQuery time.Time `json:"last_query"`
+}
+
+// series accumulates the outcomes of one resolver address
+type series struct {
+	target    benchmark.Target
+	window    *stats.Window
+	lastError string
+	lastQuery time.Time
+}
+
+// Monitor tests resolvers indefinitely and keeps rolling statistics for
+// each address. It is safe to take snapshots while it runs.
+type Monitor struct {
+	config Config
+	bench  *benchmark.Benchmark
+
+	mu     sync.Mutex
+	series map[string]*series
+	order  []string
+}
+
+// New creates a new Monitor
+func New(config Config, resolvers []resolver.Resolver) *Monitor {
+	if len(config.Windows) == 0 {
+		config.Windows = DefaultConfig().Windows
+	}
+	config.Windows = append([]time.Duration(nil), config.Windows...)
+	sort.Slice(config.Windows, func(i, j int) bool { return config.Windows[i] < config.Windows[j] })
+	config.Benchmark.OnQuery = config.OnQuery
+
+	m := &Monitor{
+		config: config,
+		bench:  benchmark.New(config.Benchmark, resolvers),
+		series: make(map[string]*series),
+	}
+	for _, t := range m.bench.Targets() {
+		key := t.Endpoint.String()
+		if _, ok := m.series[key]; ok {
+			continue
+		}
+		m.series[key] = &series{target: t, window: stats.NewWindow(config.Windows[len(config.Windows)-1])}
+		m.order = append(m.order, key)
+	}
+	return m
+}
+
+// Total returns the number of resolver addresses being monitored
+func (m *Monitor) Total() int {
+	return len(m.order)
+}
+
+// Run monitors until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) error {
+	if m.config.Rate > MaxRate {
+		return fmt.Errorf("the query rate must be at most %d per second", MaxRate)
+	}
+	if m.config.Rate > 0 {
+		m.stream(ctx)
+		return nil
+	}
+	if m.config.Interval <= 0 {
+		return fmt.Errorf("the run interval must be positive")
+	}
+	return m.schedule(ctx)
+}
+
+// schedule runs the full benchmark every interval. A run that takes longer
+// than the interval delays the next one rather than overlapping it.
+func (m *Monitor) schedule(ctx context.Context) error {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		results, err := m.bench.Run(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		m.recordRun(time.Now(), results)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordRun adds the outcomes of a benchmark run to the windows. The run's
+// queries are all timestamped with its end, as results carry no per-query
+// times.
+func (m *Monitor) recordRun(at time.Time, results []benchmark.ResolverResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range results {
+		s, ok := m.series[r.Address.String()]
+		if !ok {
+			continue
+		}
+		for _, rtt := range r.RTTs {
+			s.window.Add(at, rtt, true)
+		}
+		// Successes left out of RTTs included connection setup
+		for i := len(r.RTTs); i < r.Successes; i++ {
+			s.window.Add(at, 0, true)
+		}
+		for i := 0; i < r.Failures; i++ {
+			s.window.Add(at, 0, false)
+		}
+		s.lastQuery = at
+		s.lastError = ""
+		if len(r.Errors) > 0 {
+			s.lastError = r.Errors[len(r.Errors)-1]
+		}
+	}
+}
+
+// stream sends queries to every address at the configured rate, each
+// address on its own long-lived client
+func (m *Monitor) stream(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, key := range m.order {
+		wg.Add(1)
+		go func(s *series) {
+			defer wg.Done()
+			m.probe(ctx, s)
+		}(m.series[key])
+	}
+	wg.Wait()
+}
+
+// probe queries one address at the configured rate until ctx is cancelled
+func (m *Monitor) probe(ctx context.Context, s *series) {
+	client, err := dns.NewClient(s.target.Endpoint, m.bench.ClientOptions())
+	if err != nil {
+		m.mu.Lock()
+		s.lastError = err.Error()
+		m.mu.Unlock()
+		return
+	}
+	defer client.Close()
+
+	domains := m.config.Benchmark.Domains
+	qtypes := m.bench.QueryTypes()
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / m.config.Rate))
+	defer ticker.Stop()
+
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		domain := domains[(i/len(qtypes))%len(domains)]
+		qr := client.Query(ctx, domain, qtypes[i%len(qtypes)])
+		if ctx.Err() != nil {
+			return
+		}
+		m.record(s, qr)
+		if m.config.OnQuery != nil {
+			m.config.OnQuery(s.target, qr)
+		}
+	}
+}
+
+// record adds a single query outcome to an address's window
+func (m *Monitor) record(s *series, qr dns.QueryResult) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case !qr.Success:
+		s.window.Add(now, 0, false)
+		if qr.Error != nil {
+			s.lastError = qr.Error.Error()
+		}
+	case qr.Handshake != dns.HandshakeNone:
+		s.window.Add(now, 0, true)
+		s.lastError = ""
+	default:
+		s.window.Add(now, qr.RTT, true)
+		s.lastError = ""
+	}
+	s.lastQuery = now
+}
+
+// Snapshot returns the current statistics of every address, sorted by
+// mean latency over the shortest window with addresses that have not
+// answered last
+func (m *Monitor) Snapshot() []Status {
+	now := time.Now()
+	m.mu.Lock()
+	statuses := make([]Status, 0, len(m.order))
+	for _, key := range m.order {
+		s := m.series[key]
+		status := Status{
+			Resolver:  s.target.Resolver,
+			Address:   s.target.Endpoint,
+			Protocol:  s.target.Endpoint.Protocol,
+			LastError: s.lastError,
+			LastQuery: s.lastQuery,
+		}
+		for _, span := range m.config.Windows {
+			status.Windows = append(status.Windows, s.window.Summarize(now, span))
+		}
+		statuses = append(statuses, status)
+	}
+	m.mu.Unlock()
+
+	sort.SliceStable(statuses, func(i, j int) bool {
+		a, b := statuses[i].Windows[0], statuses[j].Windows[0]
+		if (a.Successes > 0) != (b.Successes > 0) {
+			return a.Successes > 0
+		}
+		return a.Stats.Mean < b.Stats.Mean
+	})
+	return statuses
+}
+
+// FormatSpan shortens a window span, so "1h0m0s" is shown as "1h"
+func FormatSpan(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,337 @@
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+
+	"speeddns/internal/dns"
+	"speeddns/internal/stats"
+)
+
+// Change describes how a resolver address differs between two runs
+type Change string
+
+const (
+	ChangeSame    Change = "same"
+	ChangeUp      Change = "up"
+	ChangeDown    Change = "down"
+	ChangeAdded   Change = "added"
+	ChangeRemoved Change = "removed"
+)
+
+// Comparison holds the differences between two saved JSON runs
+type Comparison struct {
+	Old *JSONRun `json:"old,omitempty"`
+	New *JSONRun `json:"new,omitempty"`
+
+	// ConfigChanges names the configuration fields that differ, when both
+	// runs recorded their configuration
+	ConfigChanges []string `json:"config_changes,omitempty"`
+
+	Resolvers []ResolverDiff `json:"resolvers"`
+}
+
+// ResolverDiff compares one resolver address across two runs. Old or new
+// values are zero when the address is missing from that run. Deltas are new
+// minus old, and significance is "", "*", "**" or "***" for differences
+// unlikely to be noise at the 5%, 1% and 0.1% levels.
+type ResolverDiff struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	Address  string `json:"address"`
+	Protocol string `json:"protocol"`
+	Change   Change `json:"change"`
+
+	OldRank int `json:"old_rank,omitempty"`
+	NewRank int `json:"new_rank,omitempty"`
+
+	OldAvgMs            float64 `json:"old_avg_ms"`
+	NewAvgMs            float64 `json:"new_avg_ms"`
+	DeltaAvgMs          float64 `json:"delta_avg_ms"`
+	OldP95Ms            float64 `json:"old_p95_ms"`
+	NewP95Ms            float64 `json:"new_p95_ms"`
+	LatencySignificance string  `json:"latency_significance,omitempty"`
+
+	OldSuccessRate      float64 `json:"old_success_rate"`
+	NewSuccessRate      float64 `json:"new_success_rate"`
+	DeltaSuccessRate    float64 `json:"delta_success_rate"`
+	SuccessSignificance string  `json:"success_significance,omitempty"`
+}
+
+// ReadJSON reads results saved with the JSON format. Output written before
+// the schema was versioned is accepted; newer schemas are not.
+func ReadJSON(r io.Reader) (JSONOutput, error) {
+	var out JSONOutput
+	if err := json.NewDecoder(r).Decode(&out); err != nil {
+		return out, err
+	}
+	if out.SchemaVersion > JSONSchemaVersion {
+		return out, fmt.Errorf("schema version %d is newer than this speeddns supports (%d)",
+			out.SchemaVersion, JSONSchemaVersion)
+	}
+	// Output written before --proto was added only measured plain UDP and
+	// has no protocol field
+	for i := range out.Results {
+		if out.Results[i].Protocol == "" {
+			out.Results[i].Protocol = string(dns.ProtoUDP)
+		}
+	}
+	return out, nil
+}
+
+// Compare matches the results of two runs by resolver and address.
+// Addresses in the new run come first, in its rank order, followed by those
+// that only the old run has. Addresses that failed every query are not in
+// saved results, so they show as added or removed.
+func Compare(older, newer JSONOutput) Comparison {
+	var cmp Comparison
+	if older.SchemaVersion >= 2 {
+		cmp.Old = &older.Run
+	}
+	if newer.SchemaVersion >= 2 {
+		cmp.New = &newer.Run
+	}
+	if cmp.Old != nil && cmp.New != nil {
+		cmp.ConfigChanges = configChanges(older.Config, newer.Config)
+	}
+
+	oldByKey := make(map[string]JSONResult, len(older.Results))
+	for _, r := range older.Results {
+		oldByKey[diffKey(r)] = r
+	}
+	matched := make(map[string]bool, len(newer.Results))
+
+	for _, n := range newer.Results {
+		key := diffKey(n)
+		o, ok := oldByKey[key]
+		if !ok {
+			cmp.Resolvers = append(cmp.Resolvers, ResolverDiff{
+				Name: n.Name, Provider: n.Provider, Address: n.Address, Protocol: n.Protocol,
+				Change:         ChangeAdded,
+				NewRank:        n.Rank,
+				NewAvgMs:       n.AvgMs,
+				NewP95Ms:       n.P95Ms,
+				NewSuccessRate: n.SuccessRate,
+			})
+			continue
+		}
+		matched[key] = true
+		cmp.Resolvers = append(cmp.Resolvers, diffResults(o, n))
+	}
+
+	var removed []ResolverDiff
+	for _, o := range older.Results {
+		if matched[diffKey(o)] {
+			continue
+		}
+		removed = append(removed, ResolverDiff{
+			Name: o.Name, Provider: o.Provider, Address: o.Address, Protocol: o.Protocol,
+			Change:         ChangeRemoved,
+			OldRank:        o.Rank,
+			OldAvgMs:       o.AvgMs,
+			OldP95Ms:       o.P95Ms,
+			OldSuccessRate: o.SuccessRate,
+		})
+	}
+	sort.SliceStable(removed, func(i, j int) bool { return removed[i].OldRank < removed[j].OldRank })
+	cmp.Resolvers = append(cmp.Resolvers, removed...)
+	return cmp
+}
+
+// diffKey identifies a resolver address across runs
+func diffKey(r JSONResult) string {
+	return r.Name + "\x00" + r.Address + "\x00" + r.Protocol
+}
+
+// diffResults compares an address present in both runs
+func diffResults(o, n JSONResult) ResolverDiff {
+	d := ResolverDiff{
+		Name: n.Name, Provider: n.Provider, Address: n.Address, Protocol: n.Protocol,
+		Change:  ChangeSame,
+		OldRank: o.Rank,
+		NewRank: n.Rank,
+
+		OldAvgMs:   o.AvgMs,
+		NewAvgMs:   n.AvgMs,
+		DeltaAvgMs: roundMs(n.AvgMs - o.AvgMs),
+		OldP95Ms:   o.P95Ms,
+		NewP95Ms:   n.P95Ms,
+		LatencySignificance: significance(stats.MeanZ(
+			o.AvgMs, o.StdDevMs, o.Successes, n.AvgMs, n.StdDevMs, n.Successes)),
+
+		OldSuccessRate:      o.SuccessRate,
+		NewSuccessRate:      n.SuccessRate,
+		DeltaSuccessRate:    n.SuccessRate - o.SuccessRate,
+		SuccessSignificance: significance(stats.ProportionZ(o.Successes, o.Queries, n.Successes, n.Queries)),
+	}
+	switch {
+	case n.Rank < o.Rank:
+		d.Change = ChangeUp
+	case n.Rank > o.Rank:
+		d.Change = ChangeDown
+	}
+	return d
+}
+
+// significance converts a z-score into a marker for the two-sided 5%, 1%
+// and 0.1% levels
+func significance(z float64) string {
+	switch z = math.Abs(z); {
+	case z >= 3.291:
+		return "***"
+	case z >= 2.576:
+		return "**"
+	case z >= 1.960:
+		return "*"
+	default:
+		return ""
+	}
+}
+
+// roundMs drops the floating point noise of subtracting millisecond values
+// that have microsecond precision
+func roundMs(ms float64) float64 {
+	return math.Round(ms*1000) / 1000
+}
+
+// configChanges returns the JSON names of the configuration fields that
+// differ between two runs
+func configChanges(older, newer JSONConfig) []string {
+	o, n := reflect.ValueOf(older), reflect.ValueOf(newer)
+	var changed []string
+	for i := 0; i < o.NumField(); i++ {
+		if !reflect.DeepEqual(o.Field(i).Interface(), n.Field(i).Interface()) {
+			name, _, _ := strings.Cut(o.Type().Field(i).Tag.Get("json"), ",")
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// FormatCompare writes a comparison of two runs in the given format
+func FormatCompare(w io.Writer, format Format, cmp Comparison) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(cmp)
+	case FormatCSV:
+		return formatCompareCSV(w, cmp)
+	default:
+		return formatCompareTable(w, cmp)
+	}
+}
+
+// formatCompareTable renders one row per resolver address with old and new
+// values side by side
+func formatCompareTable(w io.Writer, cmp Comparison) error {
+	if cmp.Old != nil && cmp.New != nil {
+		fmt.Fprintf(w, "Old: %s on %s\n", cmp.Old.Start.Local().Format("2006-01-02 15:04"), cmp.Old.Hostname)
+		fmt.Fprintf(w, "New: %s on %s\n\n", cmp.New.Start.Local().Format("2006-01-02 15:04"), cmp.New.Hostname)
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Rank", "Resolver", "Address", "Avg (ms)", "Δ Avg", "P95 (ms)", "Success", "Δ Success", "Change"})
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_CENTER)
+	table.SetColumnAlignment([]int{
+		tablewriter.ALIGN_RIGHT, // Rank
+		tablewriter.ALIGN_LEFT,  // Resolver
+		tablewriter.ALIGN_LEFT,  // Address
+		tablewriter.ALIGN_RIGHT, // Avg
+		tablewriter.ALIGN_RIGHT, // Δ Avg
+		tablewriter.ALIGN_RIGHT, // P95
+		tablewriter.ALIGN_RIGHT, // Success
+		tablewriter.ALIGN_RIGHT, // Δ Success
+		tablewriter.ALIGN_LEFT,  // Change
+	})
+
+	for _, d := range cmp.Resolvers {
+		switch d.Change {
+		case ChangeAdded:
+			table.Append([]string{
+				"- → " + strconv.Itoa(d.NewRank), d.Name, d.Address,
+				fmt.Sprintf("%.2f", d.NewAvgMs), "", fmt.Sprintf("%.2f", d.NewP95Ms),
+				fmt.Sprintf("%.1f%%", d.NewSuccessRate), "", "new",
+			})
+		case ChangeRemoved:
+			table.Append([]string{
+				strconv.Itoa(d.OldRank) + " → -", d.Name, d.Address,
+				fmt.Sprintf("%.2f", d.OldAvgMs), "", fmt.Sprintf("%.2f", d.OldP95Ms),
+				fmt.Sprintf("%.1f%%", d.OldSuccessRate), "", "gone",
+			})
+		default:
+			table.Append([]string{
+				fmt.Sprintf("%d → %d", d.OldRank, d.NewRank), d.Name, d.Address,
+				fmt.Sprintf("%.2f → %.2f", d.OldAvgMs, d.NewAvgMs),
+				fmt.Sprintf("%+.2f%s", d.DeltaAvgMs, d.LatencySignificance),
+				fmt.Sprintf("%.2f → %.2f", d.OldP95Ms, d.NewP95Ms),
+				fmt.Sprintf("%.1f%% → %.1f%%", d.OldSuccessRate, d.NewSuccessRate),
+				fmt.Sprintf("%+.1f%s", d.DeltaSuccessRate, d.SuccessSignificance),
+				rankChange(d),
+			})
+		}
+	}
+	table.Render()
+
+	fmt.Fprintln(w, "\n* p<0.05, ** p<0.01, *** p<0.001: differences unlikely to be noise.")
+	fmt.Fprintln(w, "Addresses that failed every query are not saved, so they show as new or gone.")
+	if len(cmp.ConfigChanges) > 0 {
+		fmt.Fprintf(w, "Warning: the runs used different settings (%s).\n", strings.Join(cmp.ConfigChanges, ", "))
+	}
+	if (cmp.Old != nil && cmp.Old.Partial) || (cmp.New != nil && cmp.New.Partial) {
+		fmt.Fprintln(w, "Warning: a run was interrupted; its missing addresses show as new or gone.")
+	}
+	return nil
+}
+
+// rankChange describes how far a resolver moved in the ranking
+func rankChange(d ResolverDiff) string {
+	switch d.Change {
+	case ChangeUp:
+		return fmt.Sprintf("▲ %d", d.OldRank-d.NewRank)
+	case ChangeDown:
+		return fmt.Sprintf("▼ %d", d.NewRank-d.OldRank)
+	default:
+		return ""
+	}
+}
+
+// formatCompareCSV writes one row per resolver address
+func formatCompareCSV(w io.Writer, cmp Comparison) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write([]string{
+		"resolver", "provider", "address", "protocol", "change", "old_rank", "new_rank",
+		"old_avg_ms", "new_avg_ms", "delta_avg_ms", "latency_significance", "old_p95_ms", "new_p95_ms",
+		"old_success_rate", "new_success_rate", "delta_success_rate", "success_significance",
+	}); err != nil {
+		return err
+	}
+	for _, d := range cmp.Resolvers {
+		row := []string{
+			d.Name, d.Provider, d.Address, d.Protocol, string(d.Change),
+			strconv.Itoa(d.OldRank), strconv.Itoa(d.NewRank),
+			fmt.Sprintf("%.3f", d.OldAvgMs), fmt.Sprintf("%.3f", d.NewAvgMs), fmt.Sprintf("%.3f", d.DeltaAvgMs),
+			d.LatencySignificance,
+			fmt.Sprintf("%.3f", d.OldP95Ms), fmt.Sprintf("%.3f", d.NewP95Ms),
+			fmt.Sprintf("%.2f", d.OldSuccessRate), fmt.Sprintf("%.2f", d.NewSuccessRate),
+			fmt.Sprintf("%.2f", d.DeltaSuccessRate), d.SuccessSignificance,
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
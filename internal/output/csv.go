@@ -4,43 +4,71 @@ import (
 	"encoding/csv"
 	"fmt"
 	"io"
-	"sort"
+	"strings"
 
 	"speeddns/internal/benchmark"
+	"speeddns/internal/stats"
 )
 
 // CSVFormatter outputs results as CSV
 type CSVFormatter struct {
 	writer io.Writer
+	run    Run
 }
 
 // NewCSVFormatter creates a new CSV formatter
-func NewCSVFormatter(w io.Writer) *CSVFormatter {
-	return &CSVFormatter{writer: w}
+func NewCSVFormatter(w io.Writer, run Run) *CSVFormatter {
+	return &CSVFormatter{writer: w, run: run}
 }
 
 // Format outputs results as CSV
 func (f *CSVFormatter) Format(results []benchmark.ResolverResult) error {
-	// Filter and sort
-	validResults := make([]benchmark.ResolverResult, 0, len(results))
-	for _, r := range results {
-		if r.Successes > 0 {
-			validResults = append(validResults, r)
-		}
-	}
-
-	sort.Slice(validResults, func(i, j int) bool {
-		return validResults[i].Stats.Mean < validResults[j].Stats.Mean
-	})
+	validResults := rankResults(results)
 
 	w := csv.NewWriter(f.writer)
 	defer w.Flush()
 
 	// Write header
 	header := []string{
-		"rank", "resolver", "provider", "ip", "avg_ms", "min_ms", "max_ms",
+		"rank", "resolver", "provider", "address", "protocol", "avg_ms", "min_ms", "max_ms",
 		"median_ms", "p75_ms", "p90_ms", "p95_ms", "p99_ms", "std_dev_ms",
 		"success_rate", "queries", "successes", "failures",
+		"handshake_full_ms", "handshake_resumed_ms", "zero_rtt",
+	}
+	types := queryTypes(validResults)
+	for _, t := range types {
+		header = append(header, seriesColumns(strings.ToLower(t))...)
+	}
+	header = append(header, seriesColumns("first")...)
+	header = append(header, seriesColumns("repeat")...)
+	uncached := hasUncached(validResults)
+	if uncached {
+		header = append(header, seriesColumns("uncached")...)
+	}
+	consistency := hasConsistency(validResults)
+	if consistency {
+		header = append(header, "answers_checked", "answers_agreed", "answers_divergent", "answers_empty", "answers_suspicious")
+	}
+	nxdomain := hasNXDomain(validResults)
+	if nxdomain {
+		header = append(header, "nxdomain_probes", "nxdomain_hijacked", "nxdomain_redirects")
+	}
+	dnssec := hasDNSSEC(validResults)
+	if dnssec {
+		header = append(header, "dnssec", "dnssec_ad", "dnssec_bogus_servfail", "dnssec_bogus_passed")
+	}
+	identity := hasIdentity(validResults)
+	if identity {
+		header = append(header, "pop", "egress_ip", "nsid", "server_id")
+	}
+	ecs := hasECS(validResults)
+	if ecs {
+		header = append(header, "ecs_domains", "ecs_forwarded", "ecs_changed", "ecs_plain_distance_ms", "ecs_distance_ms")
+	}
+	// Only present when the run was interrupted
+	partial := f.run.Partial
+	if partial {
+		header = append(header, "partial")
 	}
 	if err := w.Write(header); err != nil {
 		return err
@@ -53,21 +81,100 @@ func (f *CSVFormatter) Format(results []benchmark.ResolverResult) error {
 			fmt.Sprintf("%d", i+1),
 			r.Resolver.Name,
 			r.Resolver.Provider,
-			r.Address,
-			fmt.Sprintf("%.3f", float64(r.Stats.Mean.Microseconds())/1000),
-			fmt.Sprintf("%.3f", float64(r.Stats.Min.Microseconds())/1000),
-			fmt.Sprintf("%.3f", float64(r.Stats.Max.Microseconds())/1000),
-			fmt.Sprintf("%.3f", float64(r.Stats.Median.Microseconds())/1000),
-			fmt.Sprintf("%.3f", float64(r.Stats.P75.Microseconds())/1000),
-			fmt.Sprintf("%.3f", float64(r.Stats.P90.Microseconds())/1000),
-			fmt.Sprintf("%.3f", float64(r.Stats.P95.Microseconds())/1000),
-			fmt.Sprintf("%.3f", float64(r.Stats.P99.Microseconds())/1000),
-			fmt.Sprintf("%.3f", float64(r.Stats.StdDev.Microseconds())/1000),
+			r.Address.String(),
+			string(r.Protocol),
+			fmt.Sprintf("%.3f", stats.Milliseconds(r.Stats.Mean)),
+			fmt.Sprintf("%.3f", stats.Milliseconds(r.Stats.Min)),
+			fmt.Sprintf("%.3f", stats.Milliseconds(r.Stats.Max)),
+			fmt.Sprintf("%.3f", stats.Milliseconds(r.Stats.Median)),
+			fmt.Sprintf("%.3f", stats.Milliseconds(r.Stats.P75)),
+			fmt.Sprintf("%.3f", stats.Milliseconds(r.Stats.P90)),
+			fmt.Sprintf("%.3f", stats.Milliseconds(r.Stats.P95)),
+			fmt.Sprintf("%.3f", stats.Milliseconds(r.Stats.P99)),
+			fmt.Sprintf("%.3f", stats.Milliseconds(r.Stats.StdDev)),
 			fmt.Sprintf("%.2f", successRate),
 			fmt.Sprintf("%d", r.Queries),
 			fmt.Sprintf("%d", r.Successes),
 			fmt.Sprintf("%d", r.Failures),
 		}
+		if h := r.Handshakes; h != nil {
+			row = append(row,
+				fmt.Sprintf("%.3f", stats.Milliseconds(h.Full.Mean)),
+				fmt.Sprintf("%.3f", stats.Milliseconds(h.Resumed.Mean)),
+				fmt.Sprintf("%d", h.ZeroRTT),
+			)
+		} else {
+			row = append(row, "", "", "")
+		}
+		if len(types) > 0 {
+			for _, t := range r.ByType {
+				row = append(row, seriesFields(&t.Series)...)
+			}
+		}
+		row = append(row, seriesFields(&r.FirstLookup)...)
+		row = append(row, seriesFields(&r.RepeatLookup)...)
+		if uncached {
+			row = append(row, seriesFields(r.Uncached)...)
+		}
+		if consistency {
+			if c := r.Consistency; c != nil {
+				row = append(row,
+					fmt.Sprintf("%d", c.Checked),
+					fmt.Sprintf("%d", c.Agreed),
+					strings.Join(c.Divergent, "; "),
+					strings.Join(c.Empty, "; "),
+					strings.Join(c.Suspicious, "; "),
+				)
+			} else {
+				row = append(row, "", "", "", "", "")
+			}
+		}
+		if nxdomain {
+			if nx := r.NXDomain; nx != nil {
+				row = append(row,
+					fmt.Sprintf("%d", nx.Probes),
+					fmt.Sprintf("%d", nx.Hijacked),
+					strings.Join(nx.Redirects, "; "),
+				)
+			} else {
+				row = append(row, "", "", "")
+			}
+		}
+		if dnssec {
+			if d := r.DNSSEC; d != nil {
+				row = append(row,
+					d.Status(),
+					fmt.Sprintf("%t", d.AD),
+					fmt.Sprintf("%t", d.BogusServfail),
+					fmt.Sprintf("%t", d.BogusPassed),
+				)
+			} else {
+				row = append(row, "", "", "", "")
+			}
+		}
+		if identity {
+			if id := r.Identity; id != nil {
+				row = append(row, id.PoP, id.EgressIP, id.NSID, id.ServerID)
+			} else {
+				row = append(row, "", "", "", "")
+			}
+		}
+		if ecs {
+			if e := r.ECS; e != nil {
+				row = append(row,
+					fmt.Sprintf("%d", e.Domains),
+					fmt.Sprintf("%d", e.Forwarded),
+					fmt.Sprintf("%d", e.Changed),
+					fmt.Sprintf("%.3f", stats.Milliseconds(e.PlainDistance)),
+					fmt.Sprintf("%.3f", stats.Milliseconds(e.ECSDistance)),
+				)
+			} else {
+				row = append(row, "", "", "", "", "")
+			}
+		}
+		if partial {
+			row = append(row, fmt.Sprintf("%t", r.Partial))
+		}
 		if err := w.Write(row); err != nil {
 			return err
 		}
@@ -75,3 +182,20 @@ func (f *CSVFormatter) Format(results []benchmark.ResolverResult) error {
 
 	return nil
 }
+
+// seriesColumns returns the header columns for a series with the given prefix
+func seriesColumns(prefix string) []string {
+	return []string{prefix + "_avg_ms", prefix + "_p95_ms", prefix + "_success_rate"}
+}
+
+// seriesFields returns the CSV fields for a series, blank when it is missing
+func seriesFields(s *benchmark.Series) []string {
+	if s == nil {
+		return []string{"", "", ""}
+	}
+	return []string{
+		fmt.Sprintf("%.3f", stats.Milliseconds(s.Stats.Mean)),
+		fmt.Sprintf("%.3f", stats.Milliseconds(s.Stats.P95)),
+		fmt.Sprintf("%.2f", successPercent(s.Successes, s.Queries)),
+	}
+}
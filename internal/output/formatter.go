@@ -2,17 +2,23 @@ package output
 
 import (
 	"io"
+	"sort"
+	"time"
 
 	"speeddns/internal/benchmark"
+	"speeddns/internal/probe"
 )
 
 // Format represents output format type
 type Format string
 
 const (
-	FormatTable Format = "table"
-	FormatJSON  Format = "json"
-	FormatCSV   Format = "csv"
+	FormatTable       Format = "table"
+	FormatJSON        Format = "json"
+	FormatCSV         Format = "csv"
+	FormatOpenMetrics Format = "openmetrics"
+	FormatInflux      Format = "influx"
+	FormatGraphite    Format = "graphite"
 )
 
 // Formatter defines the output formatting interface
@@ -20,14 +26,156 @@ type Formatter interface {
 	Format(results []benchmark.ResolverResult) error
 }
 
+// Run describes how a set of results was produced, for formats that record
+// it alongside them
+type Run struct {
+	Version  string
+	Commit   string
+	Built    string
+	Start    time.Time
+	End      time.Time
+	Hostname string
+	Config   benchmark.Config
+	Local    []probe.LocalRoute
+
+	// Partial is set when the run was interrupted, so results may be
+	// missing or cut short
+	Partial bool
+}
+
 // New creates a formatter based on format type
-func New(format Format, w io.Writer) Formatter {
+func New(format Format, w io.Writer, run Run) Formatter {
 	switch format {
 	case FormatJSON:
-		return NewJSONFormatter(w)
+		return NewJSONFormatter(w, run)
 	case FormatCSV:
-		return NewCSVFormatter(w)
+		return NewCSVFormatter(w, run)
+	case FormatOpenMetrics:
+		return NewOpenMetricsFormatter(w)
+	case FormatInflux:
+		return NewInfluxFormatter(w)
+	case FormatGraphite:
+		return NewGraphiteFormatter(w)
 	default:
-		return NewTableFormatter(w)
+		return NewTableFormatter(w, run)
+	}
+}
+
+// rankResults drops resolvers that failed every query and sorts the rest by
+// average latency. Resolvers whose answers failed the consistency check, or
+// that rewrote nonexistent names, are ranked after all trustworthy ones, so a
+// resolver that lies fast is not first.
+func rankResults(results []benchmark.ResolverResult) []benchmark.ResolverResult {
+	valid := make([]benchmark.ResolverResult, 0, len(results))
+	for _, r := range results {
+		if r.Successes > 0 {
+			valid = append(valid, r)
+		}
+	}
+
+	sort.SliceStable(valid, func(i, j int) bool {
+		if a, b := untrustworthy(valid[i]), untrustworthy(valid[j]); a != b {
+			return b
+		}
+		return valid[i].Stats.Mean < valid[j].Stats.Mean
+	})
+	return valid
+}
+
+// untrustworthy reports whether a resolver returned answers that were flagged.
+// Divergent answers alone only count when most of them diverged.
+func untrustworthy(r benchmark.ResolverResult) bool {
+	return (r.Consistency != nil && !r.Consistency.Trustworthy()) ||
+		(r.NXDomain != nil && !r.NXDomain.OK())
+}
+
+// queryTypes returns the query types present in the results when the run
+// used more than one, so formatters only add a breakdown when it is useful
+func queryTypes(results []benchmark.ResolverResult) []string {
+	for _, r := range results {
+		if len(r.ByType) > 1 {
+			types := make([]string, len(r.ByType))
+			for i, t := range r.ByType {
+				types[i] = t.Type
+			}
+			return types
+		}
+	}
+	return nil
+}
+
+// hasUncached reports whether any result includes cache-busting lookups
+func hasUncached(results []benchmark.ResolverResult) bool {
+	for _, r := range results {
+		if r.Uncached != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasConsistency reports whether answers were checked for consistency
+func hasConsistency(results []benchmark.ResolverResult) bool {
+	for _, r := range results {
+		if r.Consistency != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasNXDomain reports whether nonexistent names were probed
+func hasNXDomain(results []benchmark.ResolverResult) bool {
+	for _, r := range results {
+		if r.NXDomain != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDNSSEC reports whether DNSSEC validation was probed
+func hasDNSSEC(results []benchmark.ResolverResult) bool {
+	for _, r := range results {
+		if r.DNSSEC != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// dnssecStatus returns the DNSSEC probe outcome, or "-" when it was not run
+func dnssecStatus(r benchmark.ResolverResult) string {
+	if r.DNSSEC == nil {
+		return "-"
+	}
+	return r.DNSSEC.Status()
+}
+
+// hasIdentity reports whether resolvers were asked to identify themselves
+func hasIdentity(results []benchmark.ResolverResult) bool {
+	for _, r := range results {
+		if r.Identity != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasECS reports whether answers with and without client subnet were compared
+func hasECS(results []benchmark.ResolverResult) bool {
+	for _, r := range results {
+		if r.ECS != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// successPercent returns successes as a percentage of queries
+func successPercent(successes, queries int) float64 {
+	if queries == 0 {
+		return 0
 	}
+	return float64(successes) / float64(queries) * 100
 }
@@ -0,0 +1,80 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"speeddns/internal/benchmark"
+	"speeddns/internal/stats"
+
+	mdns "github.com/miekg/dns"
+)
+
+// graphitePrefix is the first component of every metric path
+const graphitePrefix = "speeddns"
+
+// GraphiteFormatter outputs results in the Graphite plaintext protocol, as
+// speeddns.<name>.<transport>.<address>.<metric>. With samples, each query
+// is written under .query.<domain>.<qtype>; Graphite keeps one value per
+// path and second, so use a fine retention or the Influx format for them.
+type GraphiteFormatter struct {
+	writer io.Writer
+}
+
+// NewGraphiteFormatter creates a new Graphite plaintext formatter
+func NewGraphiteFormatter(w io.Writer) *GraphiteFormatter {
+	return &GraphiteFormatter{writer: w}
+}
+
+// Format outputs results as "path value timestamp" lines with Unix
+// timestamps in seconds. Resolvers that failed every query are included.
+func (f *GraphiteFormatter) Format(results []benchmark.ResolverResult) error {
+	w := bufio.NewWriter(f.writer)
+	now := time.Now().Unix()
+
+	for _, r := range results {
+		base := strings.Join([]string{
+			graphitePrefix,
+			graphiteComponent(r.Resolver.Name),
+			graphiteComponent(string(r.Protocol)),
+			graphiteComponent(r.Address.String()),
+		}, ".")
+
+		for _, fl := range summaryFields(r) {
+			fmt.Fprintf(w, "%s.%s %s %d\n", base, fl.name, strconv.FormatFloat(fl.value, 'f', -1, 64), now)
+		}
+
+		for _, qr := range r.Samples {
+			path := base + ".query." + graphiteComponent(qr.Domain) + "." + graphiteComponent(mdns.TypeToString[qr.QueryType])
+			success := 0
+			if qr.Success {
+				success = 1
+			}
+			fmt.Fprintf(w, "%s.success %d %d\n", path, success, qr.Time.Unix())
+			if qr.Error == nil {
+				fmt.Fprintf(w, "%s.rtt_ms %s %d\n", path, strconv.FormatFloat(stats.Milliseconds(qr.RTT), 'f', -1, 64), qr.Time.Unix())
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// graphiteComponent makes s usable as one component of a metric path by
+// replacing dots, spaces and other special characters with underscores
+func graphiteComponent(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -0,0 +1,145 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"speeddns/internal/benchmark"
+	"speeddns/internal/dns"
+	"speeddns/internal/stats"
+
+	mdns "github.com/miekg/dns"
+)
+
+// field is a named numeric value written by the telemetry formats
+type field struct {
+	name  string
+	value float64
+	count bool // an integer count rather than a measurement
+}
+
+// summaryFields returns the per-resolver values shared by the Influx and
+// Graphite formats. Latency fields are left out when nothing succeeded.
+func summaryFields(r benchmark.ResolverResult) []field {
+	fields := []field{
+		{"queries", float64(r.Queries), true},
+		{"successes", float64(r.Successes), true},
+		{"failures", float64(r.Failures), true},
+		{"success_rate", successPercent(r.Successes, r.Queries), false},
+	}
+	if r.Stats.Count == 0 {
+		return fields
+	}
+	return append(fields,
+		field{"mean_ms", stats.Milliseconds(r.Stats.Mean), false},
+		field{"min_ms", stats.Milliseconds(r.Stats.Min), false},
+		field{"max_ms", stats.Milliseconds(r.Stats.Max), false},
+		field{"median_ms", stats.Milliseconds(r.Stats.Median), false},
+		field{"p90_ms", stats.Milliseconds(r.Stats.P90), false},
+		field{"p95_ms", stats.Milliseconds(r.Stats.P95), false},
+		field{"p99_ms", stats.Milliseconds(r.Stats.P99), false},
+		field{"std_dev_ms", stats.Milliseconds(r.Stats.StdDev), false},
+	)
+}
+
+// InfluxFormatter outputs results as InfluxDB line protocol: a "speeddns"
+// point per resolver address, and a "speeddns_query" point per query when
+// the results hold samples
+type InfluxFormatter struct {
+	writer io.Writer
+}
+
+// NewInfluxFormatter creates a new InfluxDB line protocol formatter
+func NewInfluxFormatter(w io.Writer) *InfluxFormatter {
+	return &InfluxFormatter{writer: w}
+}
+
+// Format outputs results as line protocol with nanosecond timestamps.
+// Resolvers that failed every query are included.
+func (f *InfluxFormatter) Format(results []benchmark.ResolverResult) error {
+	w := bufio.NewWriter(f.writer)
+	now := time.Now()
+
+	for _, r := range results {
+		tags := influxTags(r)
+
+		var fields []string
+		for _, fl := range summaryFields(r) {
+			fields = append(fields, fl.name+"="+influxNumber(fl))
+		}
+		fmt.Fprintf(w, "speeddns%s %s %d\n", tags, strings.Join(fields, ","), now.UnixNano())
+
+		for _, qr := range r.Samples {
+			fmt.Fprintf(w, "speeddns_query%s%s %s %d\n",
+				tags, influxSampleTags(qr), influxSampleFields(qr), qr.Time.UnixNano())
+		}
+	}
+	return w.Flush()
+}
+
+// influxTags returns the tag set identifying a resolver address, with its
+// leading comma
+func influxTags(r benchmark.ResolverResult) string {
+	var b strings.Builder
+	for _, tag := range [][2]string{
+		{"name", r.Resolver.Name},
+		{"provider", r.Resolver.Provider},
+		{"address", r.Address.String()},
+		{"transport", string(r.Protocol)},
+	} {
+		// Line protocol does not allow empty tag values
+		if tag[1] != "" {
+			b.WriteString("," + tag[0] + "=" + influxEscape(tag[1]))
+		}
+	}
+	return b.String()
+}
+
+// influxSampleTags returns the tags of a single query
+func influxSampleTags(qr dns.QueryResult) string {
+	tags := ",domain=" + influxEscape(qr.Domain) + ",qtype=" + influxEscape(mdns.TypeToString[qr.QueryType])
+	if qr.Error == nil {
+		tags += ",rcode=" + mdns.RcodeToString[qr.ResponseCode]
+	}
+	return tags
+}
+
+// influxSampleFields returns the fields of a single query. The RTT is
+// only present when a response arrived.
+func influxSampleFields(qr dns.QueryResult) string {
+	fields := []string{"success=" + strconv.FormatBool(qr.Success)}
+	if qr.Error == nil {
+		fields = append(fields,
+			"rtt_ms="+strconv.FormatFloat(stats.Milliseconds(qr.RTT), 'f', -1, 64),
+			"answers="+strconv.Itoa(qr.AnswerCount)+"i",
+		)
+	}
+	if qr.Handshake != dns.HandshakeNone {
+		fields = append(fields, `handshake="`+string(qr.Handshake)+`"`)
+	}
+	if class := qr.ErrorClass(); class != "" {
+		fields = append(fields, `error_class="`+class+`"`)
+	}
+	return strings.Join(fields, ",")
+}
+
+// influxNumber formats a field value, marking counts as integers
+func influxNumber(f field) string {
+	if f.count {
+		return strconv.FormatInt(int64(f.value), 10) + "i"
+	}
+	return strconv.FormatFloat(f.value, 'f', -1, 64)
+}
+
+// influxEscaper escapes the characters that are special in tag keys and
+// values
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxEscape escapes a tag value
+func influxEscape(s string) string {
+	return influxEscaper.Replace(s)
+}
@@ -3,27 +3,41 @@ package output
 import (
 	"encoding/json"
 	"io"
-	"sort"
+	"time"
 
 	"speeddns/internal/benchmark"
+	"speeddns/internal/dns"
+	"speeddns/internal/probe"
+	"speeddns/internal/stats"
+
+	mdns "github.com/miekg/dns"
 )
 
+// JSONSchemaVersion is the version of the JSONOutput layout. It is bumped
+// whenever fields are renamed, removed or change meaning; output without
+// it predates the run metadata.
+const JSONSchemaVersion = 2
+
 // JSONFormatter outputs results as JSON
 type JSONFormatter struct {
 	writer io.Writer
+	run    Run
 }
 
-// NewJSONFormatter creates a new JSON formatter
-func NewJSONFormatter(w io.Writer) *JSONFormatter {
-	return &JSONFormatter{writer: w}
+// NewJSONFormatter creates a new JSON formatter that records how the run
+// was made along with its results
+func NewJSONFormatter(w io.Writer, run Run) *JSONFormatter {
+	return &JSONFormatter{writer: w, run: run}
 }
 
 // JSONResult is a JSON-friendly result structure
 type JSONResult struct {
 	Rank        int     `json:"rank"`
+	Partial     bool    `json:"partial,omitempty"`
 	Name        string  `json:"name"`
 	Provider    string  `json:"provider"`
 	Address     string  `json:"address"`
+	Protocol    string  `json:"protocol"`
 	AvgMs       float64 `json:"avg_ms"`
 	MinMs       float64 `json:"min_ms"`
 	MaxMs       float64 `json:"max_ms"`
@@ -37,10 +51,92 @@ type JSONResult struct {
 	Queries     int     `json:"queries"`
 	Successes   int     `json:"successes"`
 	Failures    int     `json:"failures"`
+
+	// Latencies of queries on new connections, only present for DoH and DoQ
+	HandshakeFullMs    float64 `json:"handshake_full_ms,omitempty"`
+	HandshakeResumedMs float64 `json:"handshake_resumed_ms,omitempty"`
+	ZeroRTT            int     `json:"zero_rtt,omitempty"`
+
+	// Per query type breakdown, only present when several types were queried
+	ByType []JSONTypeResult `json:"by_type,omitempty"`
+
+	// First lookup of each name vs repeats, and cache-busting lookups when enabled
+	FirstLookup  JSONSeries  `json:"first_lookup"`
+	RepeatLookup JSONSeries  `json:"repeat_lookup"`
+	Uncached     *JSONSeries `json:"uncached,omitempty"`
+
+	// Answer consistency, only present when answers were checked
+	Consistency *benchmark.Consistency `json:"consistency,omitempty"`
+
+	// NXDOMAIN rewriting, only present when nonexistent names were probed
+	NXDomain *probe.NXDomainResult `json:"nxdomain,omitempty"`
+
+	// DNSSEC validation, only present when it was probed
+	DNSSEC *JSONDNSSEC `json:"dnssec,omitempty"`
+
+	// Anycast site and egress address, only present when probed
+	Identity *probe.Identity `json:"identity,omitempty"`
+
+	// EDNS Client Subnet effect, only present when it was measured
+	ECS *JSONECS `json:"ecs,omitempty"`
+}
+
+// JSONECS compares answers with and without EDNS Client Subnet. Distances
+// are median TCP connect times to the answered addresses.
+type JSONECS struct {
+	Subnet          string  `json:"subnet"`
+	Domains         int     `json:"domains"`
+	Forwarded       int     `json:"forwarded"`
+	Changed         int     `json:"changed"`
+	PlainDistanceMs float64 `json:"plain_distance_ms"`
+	ECSDistanceMs   float64 `json:"ecs_distance_ms"`
+}
+
+// JSONDNSSEC holds the DNSSEC probe outcome along with its raw observations
+type JSONDNSSEC struct {
+	Status string `json:"status"`
+	*probe.DNSSECResult
+}
+
+// JSONSeries holds the statistics for a subset of a resolver's queries
+type JSONSeries struct {
+	AvgMs       float64 `json:"avg_ms"`
+	MedianMs    float64 `json:"median_ms"`
+	P95Ms       float64 `json:"p95_ms"`
+	P99Ms       float64 `json:"p99_ms"`
+	SuccessRate float64 `json:"success_rate"`
+	Queries     int     `json:"queries"`
+	Successes   int     `json:"successes"`
+	Failures    int     `json:"failures"`
+}
+
+// JSONTypeResult holds the statistics for one query type
+type JSONTypeResult struct {
+	Type string `json:"type"`
+	JSONSeries
+}
+
+// newJSONSeries converts a benchmark series
+func newJSONSeries(s benchmark.Series) JSONSeries {
+	return JSONSeries{
+		AvgMs:       stats.Milliseconds(s.Stats.Mean),
+		MedianMs:    stats.Milliseconds(s.Stats.Median),
+		P95Ms:       stats.Milliseconds(s.Stats.P95),
+		P99Ms:       stats.Milliseconds(s.Stats.P99),
+		SuccessRate: successPercent(s.Successes, s.Queries),
+		Queries:     s.Queries,
+		Successes:   s.Successes,
+		Failures:    s.Failures,
+	}
 }
 
 // JSONOutput wraps the results with metadata
 type JSONOutput struct {
+	SchemaVersion int        `json:"schema_version"`
+	Tool          JSONTool   `json:"tool"`
+	Run           JSONRun    `json:"run"`
+	Config        JSONConfig `json:"config"`
+
 	Results []JSONResult `json:"results"`
 	Summary struct {
 		TotalResolvers int `json:"total_resolvers"`
@@ -48,46 +144,182 @@ type JSONOutput struct {
 	} `json:"summary"`
 }
 
-// Format outputs results as JSON
-func (f *JSONFormatter) Format(results []benchmark.ResolverResult) error {
-	// Filter and sort
-	validResults := make([]benchmark.ResolverResult, 0, len(results))
-	for _, r := range results {
-		if r.Successes > 0 {
-			validResults = append(validResults, r)
-		}
+// JSONTool identifies the build of speeddns that produced the output
+type JSONTool struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Built   string `json:"built"`
+}
+
+// JSONRun records when and where the benchmark ran
+type JSONRun struct {
+	Start      time.Time          `json:"start"`
+	End        time.Time          `json:"end"`
+	DurationMs float64            `json:"duration_ms"`
+	Hostname   string             `json:"hostname"`
+	Local      []probe.LocalRoute `json:"local,omitempty"`
+
+	// Partial is set when the run was interrupted, so results may be
+	// missing or cut short
+	Partial bool `json:"partial,omitempty"`
+}
+
+// JSONConfig holds the benchmark configuration of the run. Only the OnQuery
+// callback set by --stream is left out, since it chooses where queries are
+// written rather than what is measured.
+type JSONConfig struct {
+	TimeoutMs     float64         `json:"timeout_ms"`
+	Iterations    int             `json:"iterations"`
+	Concurrency   int             `json:"concurrency"`
+	Protocols     []string        `json:"protocols"`
+	DoHPost       bool            `json:"doh_post"`
+	IncludeIPv6   bool            `json:"ipv6"`
+	Domains       []string        `json:"domains"`
+	QueryTypes    []string        `json:"query_types"`
+	UncachedZones []string        `json:"uncached_zones,omitempty"`
+	CheckAnswers  bool            `json:"check_answers"`
+	Reference     string          `json:"reference,omitempty"`
+	CheckNXDomain bool            `json:"check_nxdomain"`
+	DNSSEC        *JSONDNSSECTest `json:"dnssec,omitempty"`
+	Identify      bool            `json:"identify"`
+	ClientSubnet  string          `json:"client_subnet,omitempty"`
+	ECSReport     bool            `json:"ecs_report"`
+	KeepSamples   bool            `json:"keep_samples"`
+}
+
+// JSONDNSSECTest holds the names used by the DNSSEC probe
+type JSONDNSSECTest struct {
+	Signed string `json:"signed"`
+	Bogus  string `json:"bogus"`
+}
+
+// newJSONConfig converts a benchmark configuration
+func newJSONConfig(c benchmark.Config) JSONConfig {
+	jc := JSONConfig{
+		TimeoutMs:     stats.Milliseconds(c.Timeout),
+		Iterations:    c.Iterations,
+		Concurrency:   c.Concurrency,
+		DoHPost:       c.DoHPost,
+		IncludeIPv6:   c.IncludeIPv6,
+		Domains:       c.Domains,
+		UncachedZones: c.UncachedZones,
+		CheckAnswers:  c.CheckAnswers,
+		Reference:     c.Reference,
+		CheckNXDomain: c.CheckNXDomain,
+		Identify:      c.Identify,
+		ECSReport:     c.ECSReport,
+		KeepSamples:   c.KeepSamples,
+	}
+	protocols := c.Protocols
+	if len(protocols) == 0 {
+		protocols = []dns.Protocol{dns.ProtoUDP}
+	}
+	for _, p := range protocols {
+		jc.Protocols = append(jc.Protocols, string(p))
+	}
+	queryTypes := c.QueryTypes
+	if len(queryTypes) == 0 {
+		queryTypes = []uint16{mdns.TypeA}
 	}
+	for _, qtype := range queryTypes {
+		jc.QueryTypes = append(jc.QueryTypes, mdns.TypeToString[qtype])
+	}
+	if c.DNSSEC != nil {
+		jc.DNSSEC = &JSONDNSSECTest{Signed: c.DNSSEC.Signed, Bogus: c.DNSSEC.Bogus}
+	}
+	if c.ClientSubnet.IsValid() {
+		jc.ClientSubnet = c.ClientSubnet.String()
+	}
+	return jc
+}
 
-	sort.Slice(validResults, func(i, j int) bool {
-		return validResults[i].Stats.Mean < validResults[j].Stats.Mean
-	})
+// Format outputs results as JSON
+func (f *JSONFormatter) Format(results []benchmark.ResolverResult) error {
+	validResults := rankResults(results)
 
-	output := JSONOutput{}
+	output := JSONOutput{
+		SchemaVersion: JSONSchemaVersion,
+		Tool: JSONTool{
+			Name:    "speeddns",
+			Version: f.run.Version,
+			Commit:  f.run.Commit,
+			Built:   f.run.Built,
+		},
+		Run: JSONRun{
+			Start:      f.run.Start,
+			End:        f.run.End,
+			DurationMs: stats.Milliseconds(f.run.End.Sub(f.run.Start)),
+			Hostname:   f.run.Hostname,
+			Local:      f.run.Local,
+			Partial:    f.run.Partial,
+		},
+		Config: newJSONConfig(f.run.Config),
+	}
 	output.Summary.TotalResolvers = len(results)
 	output.Summary.SuccessfulOnly = len(validResults)
 
 	for i, r := range validResults {
 		successRate := float64(r.Successes) / float64(r.Queries) * 100
 
-		output.Results = append(output.Results, JSONResult{
+		jr := JSONResult{
 			Rank:        i + 1,
+			Partial:     r.Partial,
 			Name:        r.Resolver.Name,
 			Provider:    r.Resolver.Provider,
-			Address:     r.Address,
-			AvgMs:       float64(r.Stats.Mean.Microseconds()) / 1000,
-			MinMs:       float64(r.Stats.Min.Microseconds()) / 1000,
-			MaxMs:       float64(r.Stats.Max.Microseconds()) / 1000,
-			MedianMs:    float64(r.Stats.Median.Microseconds()) / 1000,
-			P75Ms:       float64(r.Stats.P75.Microseconds()) / 1000,
-			P90Ms:       float64(r.Stats.P90.Microseconds()) / 1000,
-			P95Ms:       float64(r.Stats.P95.Microseconds()) / 1000,
-			P99Ms:       float64(r.Stats.P99.Microseconds()) / 1000,
-			StdDevMs:    float64(r.Stats.StdDev.Microseconds()) / 1000,
+			Address:     r.Address.String(),
+			Protocol:    string(r.Protocol),
+			AvgMs:       stats.Milliseconds(r.Stats.Mean),
+			MinMs:       stats.Milliseconds(r.Stats.Min),
+			MaxMs:       stats.Milliseconds(r.Stats.Max),
+			MedianMs:    stats.Milliseconds(r.Stats.Median),
+			P75Ms:       stats.Milliseconds(r.Stats.P75),
+			P90Ms:       stats.Milliseconds(r.Stats.P90),
+			P95Ms:       stats.Milliseconds(r.Stats.P95),
+			P99Ms:       stats.Milliseconds(r.Stats.P99),
+			StdDevMs:    stats.Milliseconds(r.Stats.StdDev),
 			SuccessRate: successRate,
 			Queries:     r.Queries,
 			Successes:   r.Successes,
 			Failures:    r.Failures,
-		})
+
+			FirstLookup:  newJSONSeries(r.FirstLookup),
+			RepeatLookup: newJSONSeries(r.RepeatLookup),
+			Consistency:  r.Consistency,
+			NXDomain:     r.NXDomain,
+			Identity:     r.Identity,
+		}
+		if h := r.Handshakes; h != nil {
+			jr.HandshakeFullMs = stats.Milliseconds(h.Full.Mean)
+			jr.HandshakeResumedMs = stats.Milliseconds(h.Resumed.Mean)
+			jr.ZeroRTT = h.ZeroRTT
+		}
+		if len(r.ByType) > 1 {
+			for _, t := range r.ByType {
+				jr.ByType = append(jr.ByType, JSONTypeResult{
+					Type:       t.Type,
+					JSONSeries: newJSONSeries(t.Series),
+				})
+			}
+		}
+		if r.Uncached != nil {
+			uncached := newJSONSeries(*r.Uncached)
+			jr.Uncached = &uncached
+		}
+		if e := r.ECS; e != nil {
+			jr.ECS = &JSONECS{
+				Subnet:          e.Subnet,
+				Domains:         e.Domains,
+				Forwarded:       e.Forwarded,
+				Changed:         e.Changed,
+				PlainDistanceMs: stats.Milliseconds(e.PlainDistance),
+				ECSDistanceMs:   stats.Milliseconds(e.ECSDistance),
+			}
+		}
+		if r.DNSSEC != nil {
+			jr.DNSSEC = &JSONDNSSEC{Status: r.DNSSEC.Status(), DNSSECResult: r.DNSSEC}
+		}
+		output.Results = append(output.Results, jr)
 	}
 
 	encoder := json.NewEncoder(f.writer)
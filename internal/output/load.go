@@ -0,0 +1,194 @@
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/olekukonko/tablewriter"
+
+	"speeddns/internal/loadtest"
+	"speeddns/internal/stats"
+)
+
+// JSONLoadStep is a JSON-friendly load step
+type JSONLoadStep struct {
+	TargetQPS   int     `json:"target_qps"`
+	AchievedQPS float64 `json:"achieved_qps"`
+	Sent        int     `json:"sent"`
+	Answered    int     `json:"answered"`
+	Lost        int     `json:"lost"`
+	Errors      int     `json:"errors"`
+	Dropped     int     `json:"dropped"`
+	LossRate    float64 `json:"loss_rate"`
+	P50Ms       float64 `json:"p50_ms"`
+	P90Ms       float64 `json:"p90_ms"`
+	P99Ms       float64 `json:"p99_ms"`
+	MaxMs       float64 `json:"max_ms"`
+
+	// Uncorrected p99, measured from the actual send time
+	ServiceP99Ms float64 `json:"service_p99_ms"`
+
+	Saturated bool   `json:"saturated"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// JSONLoadReport is a JSON-friendly load test report for one resolver
+type JSONLoadReport struct {
+	Target        string         `json:"target"`
+	SaturationQPS int            `json:"saturation_qps"`
+	Saturated     bool           `json:"saturated"`
+	Error         string         `json:"error,omitempty"`
+	Partial       bool           `json:"partial,omitempty"`
+	Steps         []JSONLoadStep `json:"steps"`
+}
+
+// FormatLoad writes load test reports in the given format
+func FormatLoad(w io.Writer, format Format, reports []loadtest.Report) error {
+	switch format {
+	case FormatJSON:
+		return formatLoadJSON(w, reports)
+	case FormatCSV:
+		return formatLoadCSV(w, reports)
+	default:
+		return formatLoadTable(w, reports)
+	}
+}
+
+// formatLoadTable renders one table per resolver with a row per step
+func formatLoadTable(w io.Writer, reports []loadtest.Report) error {
+	for _, r := range reports {
+		fmt.Fprintf(w, "\n%s\n", r.Target.String())
+		if r.Error != "" {
+			fmt.Fprintf(w, "  error: %s\n", r.Error)
+			continue
+		}
+
+		table := tablewriter.NewWriter(w)
+		table.SetHeader([]string{
+			"Target QPS", "Achieved", "Sent", "Loss", "Errors", "Dropped",
+			"P50", "P90", "P99", "Max", "Service P99", "Status",
+		})
+		table.SetBorder(true)
+		table.SetAutoWrapText(false)
+		table.SetAlignment(tablewriter.ALIGN_RIGHT)
+		table.SetHeaderAlignment(tablewriter.ALIGN_CENTER)
+
+		for _, s := range r.Steps {
+			status := "ok"
+			if s.Saturated {
+				status = s.Reason
+			}
+			table.Append([]string{
+				fmt.Sprintf("%d", s.TargetQPS),
+				fmt.Sprintf("%.0f", s.AchievedQPS),
+				fmt.Sprintf("%d", s.Sent),
+				fmt.Sprintf("%.2f%%", s.LossRate()*100),
+				fmt.Sprintf("%d", s.Errors),
+				fmt.Sprintf("%d", s.Dropped),
+				formatDuration(s.Latency.P50),
+				formatDuration(s.Latency.P90),
+				formatDuration(s.Latency.P99),
+				formatDuration(s.Latency.Max),
+				formatDuration(s.ServiceTime.P99),
+				status,
+			})
+		}
+		table.Render()
+
+		switch {
+		case r.Partial:
+			fmt.Fprintf(w, "Interrupted: no saturation up to %d QPS in the steps that finished.\n", r.SaturationQPS)
+		case r.Saturated && r.SaturationQPS == 0:
+			fmt.Fprintln(w, "Saturated at the first step.")
+		case r.Saturated:
+			fmt.Fprintf(w, "Saturation: %d QPS was the highest rate within thresholds.\n", r.SaturationQPS)
+		default:
+			fmt.Fprintf(w, "No saturation up to %d QPS.\n", r.SaturationQPS)
+		}
+	}
+	fmt.Fprintln(w, "\nLatencies are measured from the scheduled send time (corrected for coordinated omission);")
+	fmt.Fprintln(w, "Service P99 is measured from the actual send time. Dropped sends were skipped at")
+	fmt.Fprintln(w, "--max-inflight and are not counted as loss.")
+	return nil
+}
+
+// formatLoadJSON writes the reports as a JSON array
+func formatLoadJSON(w io.Writer, reports []loadtest.Report) error {
+	out := make([]JSONLoadReport, 0, len(reports))
+	for _, r := range reports {
+		jr := JSONLoadReport{
+			Target:        r.Target.String(),
+			SaturationQPS: r.SaturationQPS,
+			Saturated:     r.Saturated,
+			Error:         r.Error,
+			Partial:       r.Partial,
+			Steps:         []JSONLoadStep{},
+		}
+		for _, s := range r.Steps {
+			jr.Steps = append(jr.Steps, JSONLoadStep{
+				TargetQPS:    s.TargetQPS,
+				AchievedQPS:  s.AchievedQPS,
+				Sent:         s.Sent,
+				Answered:     s.Answered,
+				Lost:         s.Lost,
+				Errors:       s.Errors,
+				Dropped:      s.Dropped,
+				LossRate:     s.LossRate(),
+				P50Ms:        stats.Milliseconds(s.Latency.P50),
+				P90Ms:        stats.Milliseconds(s.Latency.P90),
+				P99Ms:        stats.Milliseconds(s.Latency.P99),
+				MaxMs:        stats.Milliseconds(s.Latency.Max),
+				ServiceP99Ms: stats.Milliseconds(s.ServiceTime.P99),
+				Saturated:    s.Saturated,
+				Reason:       s.Reason,
+			})
+		}
+		out = append(out, jr)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// formatLoadCSV writes one record per resolver and step
+func formatLoadCSV(w io.Writer, reports []loadtest.Report) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	header := []string{
+		"target", "target_qps", "achieved_qps", "sent", "answered", "lost", "errors", "dropped",
+		"loss_rate", "p50_ms", "p90_ms", "p99_ms", "max_ms", "service_p99_ms", "saturated", "reason",
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range reports {
+		for _, s := range r.Steps {
+			row := []string{
+				r.Target.String(),
+				fmt.Sprintf("%d", s.TargetQPS),
+				fmt.Sprintf("%.1f", s.AchievedQPS),
+				fmt.Sprintf("%d", s.Sent),
+				fmt.Sprintf("%d", s.Answered),
+				fmt.Sprintf("%d", s.Lost),
+				fmt.Sprintf("%d", s.Errors),
+				fmt.Sprintf("%d", s.Dropped),
+				fmt.Sprintf("%.4f", s.LossRate()),
+				fmt.Sprintf("%.3f", stats.Milliseconds(s.Latency.P50)),
+				fmt.Sprintf("%.3f", stats.Milliseconds(s.Latency.P90)),
+				fmt.Sprintf("%.3f", stats.Milliseconds(s.Latency.P99)),
+				fmt.Sprintf("%.3f", stats.Milliseconds(s.Latency.Max)),
+				fmt.Sprintf("%.3f", stats.Milliseconds(s.ServiceTime.P99)),
+				fmt.Sprintf("%t", s.Saturated),
+				s.Reason,
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
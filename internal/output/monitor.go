@@ -0,0 +1,178 @@
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+
+	"speeddns/internal/monitor"
+	"speeddns/internal/stats"
+)
+
+// JSONWindow is a JSON-friendly rolling window summary
+type JSONWindow struct {
+	Span         string  `json:"span"`
+	Queries      int     `json:"queries"`
+	Failures     int     `json:"failures"`
+	Availability float64 `json:"availability"`
+	MeanMs       float64 `json:"mean_ms"`
+	P50Ms        float64 `json:"p50_ms"`
+	P95Ms        float64 `json:"p95_ms"`
+	P99Ms        float64 `json:"p99_ms"`
+}
+
+// JSONMonitorStatus is a JSON-friendly status of one monitored address
+type JSONMonitorStatus struct {
+	Name      string       `json:"name"`
+	Provider  string       `json:"provider"`
+	Address   string       `json:"address"`
+	Protocol  string       `json:"protocol"`
+	LastError string       `json:"last_error,omitempty"`
+	Windows   []JSONWindow `json:"windows"`
+}
+
+// JSONMonitorReport is one periodic monitor report
+type JSONMonitorReport struct {
+	Time      time.Time           `json:"time"`
+	Resolvers []JSONMonitorStatus `json:"resolvers"`
+}
+
+// MonitorFormatter writes periodic monitor reports. Table reports are
+// written one after another, JSON reports one per line and CSV reports as
+// records under a single header.
+type MonitorFormatter struct {
+	format Format
+	w      io.Writer
+	header bool
+}
+
+// NewMonitorFormatter creates a formatter for monitor reports
+func NewMonitorFormatter(format Format, w io.Writer) *MonitorFormatter {
+	return &MonitorFormatter{format: format, w: w}
+}
+
+// Format writes the statuses as of the given time
+func (f *MonitorFormatter) Format(at time.Time, statuses []monitor.Status) error {
+	switch f.format {
+	case FormatJSON:
+		return f.formatJSON(at, statuses)
+	case FormatCSV:
+		return f.formatCSV(at, statuses)
+	default:
+		return f.formatTable(at, statuses)
+	}
+}
+
+// formatTable renders a row per address with mean, p95 and availability
+// columns for each window
+func (f *MonitorFormatter) formatTable(at time.Time, statuses []monitor.Status) error {
+	fmt.Fprintf(f.w, "\n%s\n", at.Format(time.DateTime))
+	if len(statuses) == 0 {
+		return nil
+	}
+
+	header := []string{"Resolver", "Address"}
+	alignment := []int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT}
+	for _, ws := range statuses[0].Windows {
+		span := monitor.FormatSpan(ws.Span)
+		header = append(header, "Avg "+span, "P95 "+span, "OK "+span)
+		alignment = append(alignment, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT)
+	}
+	header = append(header, "Last Error")
+	alignment = append(alignment, tablewriter.ALIGN_LEFT)
+
+	table := tablewriter.NewWriter(f.w)
+	table.SetHeader(header)
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetColumnAlignment(alignment)
+	table.SetHeaderAlignment(tablewriter.ALIGN_CENTER)
+
+	for _, s := range statuses {
+		row := []string{s.Resolver.Name, s.Address.String()}
+		for _, ws := range s.Windows {
+			avail := "-"
+			if ws.Queries > 0 {
+				avail = fmt.Sprintf("%.1f%%", ws.Availability()*100)
+			}
+			row = append(row, formatDuration(ws.Stats.Mean), formatDuration(ws.Stats.P95), avail)
+		}
+		row = append(row, s.LastError)
+		table.Append(row)
+	}
+	table.Render()
+	return nil
+}
+
+// formatJSON writes the report as a single line
+func (f *MonitorFormatter) formatJSON(at time.Time, statuses []monitor.Status) error {
+	report := JSONMonitorReport{Time: at, Resolvers: []JSONMonitorStatus{}}
+	for _, s := range statuses {
+		js := JSONMonitorStatus{
+			Name:      s.Resolver.Name,
+			Provider:  s.Resolver.Provider,
+			Address:   s.Address.String(),
+			Protocol:  string(s.Protocol),
+			LastError: s.LastError,
+		}
+		for _, ws := range s.Windows {
+			js.Windows = append(js.Windows, JSONWindow{
+				Span:         monitor.FormatSpan(ws.Span),
+				Queries:      ws.Queries,
+				Failures:     ws.Failures,
+				Availability: ws.Availability(),
+				MeanMs:       stats.Milliseconds(ws.Stats.Mean),
+				P50Ms:        stats.Milliseconds(ws.Stats.P50),
+				P95Ms:        stats.Milliseconds(ws.Stats.P95),
+				P99Ms:        stats.Milliseconds(ws.Stats.P99),
+			})
+		}
+		report.Resolvers = append(report.Resolvers, js)
+	}
+	return json.NewEncoder(f.w).Encode(report)
+}
+
+// formatCSV writes one record per address and window, with the header
+// before the first report only
+func (f *MonitorFormatter) formatCSV(at time.Time, statuses []monitor.Status) error {
+	cw := csv.NewWriter(f.w)
+	defer cw.Flush()
+
+	if !f.header {
+		header := []string{
+			"time", "name", "provider", "address", "protocol", "window",
+			"queries", "failures", "availability", "mean_ms", "p50_ms", "p95_ms", "p99_ms",
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		f.header = true
+	}
+	for _, s := range statuses {
+		for _, ws := range s.Windows {
+			row := []string{
+				at.Format(time.RFC3339),
+				s.Resolver.Name,
+				s.Resolver.Provider,
+				s.Address.String(),
+				string(s.Protocol),
+				monitor.FormatSpan(ws.Span),
+				fmt.Sprintf("%d", ws.Queries),
+				fmt.Sprintf("%d", ws.Failures),
+				fmt.Sprintf("%.4f", ws.Availability()),
+				fmt.Sprintf("%.3f", stats.Milliseconds(ws.Stats.Mean)),
+				fmt.Sprintf("%.3f", stats.Milliseconds(ws.Stats.P50)),
+				fmt.Sprintf("%.3f", stats.Milliseconds(ws.Stats.P95)),
+				fmt.Sprintf("%.3f", stats.Milliseconds(ws.Stats.P99)),
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
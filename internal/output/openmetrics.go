@@ -0,0 +1,43 @@
+package output
+
+import (
+	"io"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+
+	"speeddns/internal/benchmark"
+	"speeddns/internal/metrics"
+)
+
+// OpenMetricsFormatter outputs results in the OpenMetrics exposition
+// format, for node_exporter's textfile collector to pick up
+type OpenMetricsFormatter struct {
+	writer io.Writer
+}
+
+// NewOpenMetricsFormatter creates a new OpenMetrics formatter
+func NewOpenMetricsFormatter(w io.Writer) *OpenMetricsFormatter {
+	return &OpenMetricsFormatter{writer: w}
+}
+
+// Format outputs results as metrics. Unlike the other formats, resolvers
+// that failed every query are included, so their failures can be alerted on.
+func (f *OpenMetricsFormatter) Format(results []benchmark.ResolverResult) error {
+	families, err := metrics.Results(results, time.Now()).Gather()
+	if err != nil {
+		return err
+	}
+
+	enc := expfmt.NewEncoder(f.writer, expfmt.NewFormat(expfmt.TypeOpenMetrics))
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	// Close writes the "# EOF" line that ends an OpenMetrics exposition
+	if closer, ok := enc.(expfmt.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package output
+
+import (
+	"fmt"
+	"io"
+
+	"speeddns/internal/slo"
+)
+
+// FormatViolations writes a report of the rules that were not met, grouped
+// by rule, or a line saying that all of them held
+func FormatViolations(w io.Writer, rules []slo.Rule, violations []slo.Violation) {
+	if len(violations) == 0 {
+		fmt.Fprintf(w, "All %d SLO assertion(s) passed.\n", len(rules))
+		return
+	}
+
+	byRule := make(map[string][]slo.Violation)
+	for _, v := range violations {
+		byRule[v.Rule.Text] = append(byRule[v.Rule.Text], v)
+	}
+
+	fmt.Fprintf(w, "SLO violations (%d of %d assertion(s) failed):\n", len(byRule), len(rules))
+	for _, rule := range rules {
+		vs, ok := byRule[rule.Text]
+		if !ok {
+			continue
+		}
+		delete(byRule, rule.Text) // the same rule may be given twice
+		fmt.Fprintf(w, "\n  %s\n", rule.Text)
+		for _, v := range vs {
+			if v.Resolver == "" {
+				fmt.Fprintf(w, "    %s\n", v.Detail)
+				continue
+			}
+			name := v.Resolver
+			if v.Address != v.Resolver {
+				name += " " + v.Address
+			}
+			fmt.Fprintf(w, "    %s (%s): %s\n", name, v.Protocol, v.Detail)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"speeddns/internal/benchmark"
+	"speeddns/internal/dns"
+	"speeddns/internal/stats"
+
+	mdns "github.com/miekg/dns"
+)
+
+// JSONQuery is a single query as written by a QueryStream
+type JSONQuery struct {
+	Time       time.Time `json:"time"`
+	Resolver   string    `json:"resolver"`
+	Provider   string    `json:"provider"`
+	Address    string    `json:"address"`
+	Protocol   string    `json:"protocol"`
+	Domain     string    `json:"domain"`
+	QueryType  string    `json:"qtype"`
+	Success    bool      `json:"success"`
+	ErrorClass string    `json:"error_class,omitempty"`
+	Error      string    `json:"error,omitempty"`
+
+	// Response details, null when no response arrived
+	RTTMs   *float64 `json:"rtt_ms"`
+	Rcode   *string  `json:"rcode"`
+	Answers *int     `json:"answers"`
+
+	// Connection setup included in the RTT, if any
+	Handshake string `json:"handshake,omitempty"`
+}
+
+// QueryStream writes every query as one line of JSON while a run is in
+// progress. It is safe for concurrent use, so Write can be used directly
+// as a benchmark OnQuery hook.
+type QueryStream struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	enc *json.Encoder
+	err error
+}
+
+// NewQueryStream creates a query stream writing to w
+func NewQueryStream(w io.Writer) *QueryStream {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	return &QueryStream{w: bw, enc: enc}
+}
+
+// Write writes a completed query. Lines are flushed as they are written so
+// the stream can be followed; the first error is kept for Close.
+func (s *QueryStream) Write(t benchmark.Target, qr dns.QueryResult) {
+	q := JSONQuery{
+		Time:       qr.Time,
+		Resolver:   t.Resolver.Name,
+		Provider:   t.Resolver.Provider,
+		Address:    t.Endpoint.String(),
+		Protocol:   string(t.Endpoint.Protocol),
+		Domain:     qr.Domain,
+		QueryType:  mdns.TypeToString[qr.QueryType],
+		Success:    qr.Success,
+		ErrorClass: qr.ErrorClass(),
+		Handshake:  string(qr.Handshake),
+	}
+	if qr.Error != nil {
+		q.Error = qr.Error.Error()
+	} else {
+		rtt := stats.Milliseconds(qr.RTT)
+		rcode := mdns.RcodeToString[qr.ResponseCode]
+		answers := qr.AnswerCount
+		q.RTTMs, q.Rcode, q.Answers = &rtt, &rcode, &answers
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	if err := s.enc.Encode(q); err != nil {
+		s.err = err
+		return
+	}
+	s.err = s.w.Flush()
+}
+
+// Close returns the first error writing the stream
+func (s *QueryStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return s.w.Flush()
+}
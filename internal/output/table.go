@@ -3,44 +3,60 @@ package output
 import (
 	"fmt"
 	"io"
-	"sort"
+	"strings"
 	"time"
 
 	"github.com/olekukonko/tablewriter"
 
 	"speeddns/internal/benchmark"
+	"speeddns/internal/stats"
 )
 
 // TableFormatter outputs results as ASCII table
 type TableFormatter struct {
 	writer io.Writer
+	run    Run
 }
 
 // NewTableFormatter creates a new table formatter
-func NewTableFormatter(w io.Writer) *TableFormatter {
-	return &TableFormatter{writer: w}
+func NewTableFormatter(w io.Writer, run Run) *TableFormatter {
+	return &TableFormatter{writer: w, run: run}
 }
 
 // Format outputs results as a formatted table
 func (f *TableFormatter) Format(results []benchmark.ResolverResult) error {
-	// Filter out results with no successful queries
-	validResults := make([]benchmark.ResolverResult, 0, len(results))
-	for _, r := range results {
-		if r.Successes > 0 {
-			validResults = append(validResults, r)
-		}
+	validResults := rankResults(results)
+
+	if f.run.Partial {
+		fmt.Fprintln(f.writer, "PARTIAL RESULTS: the run was interrupted. Addresses not yet tested are missing,")
+		fmt.Fprintln(f.writer, "and those marked (partial) were stopped before all of their queries.")
+		fmt.Fprintln(f.writer)
 	}
 
-	// Sort by average latency
-	sort.Slice(validResults, func(i, j int) bool {
-		return validResults[i].Stats.Mean < validResults[j].Stats.Mean
-	})
+	header := []string{
+		"Rank", "Resolver", "Address", "Proto", "Avg", "Min", "Max",
+		"P95", "Success", "Queries",
+	}
+	alignment := []int{
+		tablewriter.ALIGN_RIGHT, // Rank
+		tablewriter.ALIGN_LEFT,  // Resolver
+		tablewriter.ALIGN_LEFT,  // Address
+		tablewriter.ALIGN_LEFT,  // Proto
+		tablewriter.ALIGN_RIGHT, // Avg
+		tablewriter.ALIGN_RIGHT, // Min
+		tablewriter.ALIGN_RIGHT, // Max
+		tablewriter.ALIGN_RIGHT, // P95
+		tablewriter.ALIGN_RIGHT, // Success
+		tablewriter.ALIGN_RIGHT, // Queries
+	}
+	dnssec := hasDNSSEC(validResults)
+	if dnssec {
+		header = append(header, "DNSSEC")
+		alignment = append(alignment, tablewriter.ALIGN_LEFT)
+	}
 
 	table := tablewriter.NewWriter(f.writer)
-	table.SetHeader([]string{
-		"Rank", "Resolver", "IP", "Avg", "Min", "Max",
-		"P95", "Success", "Queries",
-	})
+	table.SetHeader(header)
 
 	// Configure table style
 	table.SetBorder(true)
@@ -48,36 +64,56 @@ func (f *TableFormatter) Format(results []benchmark.ResolverResult) error {
 	table.SetAutoWrapText(false)
 	table.SetAlignment(tablewriter.ALIGN_RIGHT)
 	table.SetHeaderAlignment(tablewriter.ALIGN_CENTER)
-	table.SetColumnAlignment([]int{
-		tablewriter.ALIGN_RIGHT,  // Rank
-		tablewriter.ALIGN_LEFT,   // Resolver
-		tablewriter.ALIGN_LEFT,   // IP
-		tablewriter.ALIGN_RIGHT,  // Avg
-		tablewriter.ALIGN_RIGHT,  // Min
-		tablewriter.ALIGN_RIGHT,  // Max
-		tablewriter.ALIGN_RIGHT,  // P95
-		tablewriter.ALIGN_RIGHT,  // Success
-		tablewriter.ALIGN_RIGHT,  // Queries
-	})
+	table.SetColumnAlignment(alignment)
 
 	for i, r := range validResults {
 		successRate := float64(r.Successes) / float64(r.Queries) * 100
 
-		table.Append([]string{
+		row := []string{
 			fmt.Sprintf("%d", i+1),
 			r.Resolver.Name,
-			r.Address,
+			r.Address.String(),
+			string(r.Protocol),
 			formatDuration(r.Stats.Mean),
 			formatDuration(r.Stats.Min),
 			formatDuration(r.Stats.Max),
 			formatDuration(r.Stats.P95),
 			fmt.Sprintf("%.1f%%", successRate),
 			fmt.Sprintf("%d", r.Queries),
-		})
+		}
+		if r.Partial {
+			row[9] += " (partial)"
+		}
+		if dnssec {
+			row = append(row, dnssecStatus(r))
+		}
+		table.Append(row)
 	}
 
 	table.Render()
 
+	if err := f.formatHandshakes(validResults); err != nil {
+		return err
+	}
+	if err := f.formatByType(validResults); err != nil {
+		return err
+	}
+	if err := f.formatCache(validResults); err != nil {
+		return err
+	}
+	if err := f.formatConsistency(validResults); err != nil {
+		return err
+	}
+	if err := f.formatNXDomain(validResults); err != nil {
+		return err
+	}
+	if err := f.formatIdentity(validResults); err != nil {
+		return err
+	}
+	if err := f.formatECS(validResults); err != nil {
+		return err
+	}
+
 	// Show failed resolvers if any
 	failedCount := len(results) - len(validResults)
 	if failedCount > 0 {
@@ -87,6 +123,321 @@ func (f *TableFormatter) Format(results []benchmark.ResolverResult) error {
 	return nil
 }
 
+// formatHandshakes renders connection setup latencies for results that have them
+func (f *TableFormatter) formatHandshakes(results []benchmark.ResolverResult) error {
+	var rows [][]string
+	for _, r := range results {
+		h := r.Handshakes
+		if h == nil {
+			continue
+		}
+		rows = append(rows, []string{
+			r.Resolver.Name,
+			r.Address.String(),
+			string(r.Protocol),
+			formatDuration(h.Full.Mean),
+			formatDuration(h.Resumed.Mean),
+			fmt.Sprintf("%d/%d", h.ZeroRTT, h.Resumed.Count),
+		})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(f.writer, "\nConnection setup (first query on a new connection):")
+	table := tablewriter.NewWriter(f.writer)
+	table.SetHeader([]string{"Resolver", "Address", "Proto", "Full", "Resumed", "0-RTT"})
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	table.SetHeaderAlignment(tablewriter.ALIGN_CENTER)
+	table.SetColumnAlignment([]int{
+		tablewriter.ALIGN_LEFT,  // Resolver
+		tablewriter.ALIGN_LEFT,  // Address
+		tablewriter.ALIGN_LEFT,  // Proto
+		tablewriter.ALIGN_RIGHT, // Full
+		tablewriter.ALIGN_RIGHT, // Resumed
+		tablewriter.ALIGN_RIGHT, // 0-RTT
+	})
+	table.AppendBulk(rows)
+	table.Render()
+	return nil
+}
+
+// formatByType renders mean latency per query type when several were queried
+func (f *TableFormatter) formatByType(results []benchmark.ResolverResult) error {
+	types := queryTypes(results)
+	if len(types) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(f.writer, "\nAverage latency by query type:")
+	table := tablewriter.NewWriter(f.writer)
+	table.SetHeader(append([]string{"Resolver", "Address"}, types...))
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	table.SetHeaderAlignment(tablewriter.ALIGN_CENTER)
+	alignment := []int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT}
+	for range types {
+		alignment = append(alignment, tablewriter.ALIGN_RIGHT)
+	}
+	table.SetColumnAlignment(alignment)
+
+	for _, r := range results {
+		row := []string{r.Resolver.Name, r.Address.String()}
+		for _, t := range r.ByType {
+			cell := formatDuration(t.Stats.Mean)
+			if t.Failures > 0 {
+				cell += fmt.Sprintf(" (%.0f%%)", successPercent(t.Successes, t.Queries))
+			}
+			row = append(row, cell)
+		}
+		table.Append(row)
+	}
+	table.Render()
+	return nil
+}
+
+// formatCache renders first-lookup vs repeat-lookup latency, plus
+// cache-busting lookups when they were enabled
+func (f *TableFormatter) formatCache(results []benchmark.ResolverResult) error {
+	repeats := false
+	for _, r := range results {
+		if r.RepeatLookup.Queries > 0 {
+			repeats = true
+		}
+	}
+	uncached := hasUncached(results)
+	if !repeats && !uncached {
+		return nil
+	}
+
+	header := []string{"Resolver", "Address", "First", "First P95", "Repeat", "Repeat P95"}
+	alignment := []int{
+		tablewriter.ALIGN_LEFT,  // Resolver
+		tablewriter.ALIGN_LEFT,  // Address
+		tablewriter.ALIGN_RIGHT, // First
+		tablewriter.ALIGN_RIGHT, // First P95
+		tablewriter.ALIGN_RIGHT, // Repeat
+		tablewriter.ALIGN_RIGHT, // Repeat P95
+	}
+	if uncached {
+		header = append(header, "Uncached", "Uncached P95", "Uncached OK")
+		alignment = append(alignment,
+			tablewriter.ALIGN_RIGHT, // Uncached
+			tablewriter.ALIGN_RIGHT, // Uncached P95
+			tablewriter.ALIGN_RIGHT, // Uncached OK
+		)
+	}
+
+	fmt.Fprintln(f.writer, "\nCache behaviour (first lookup of each name vs repeats):")
+	table := tablewriter.NewWriter(f.writer)
+	table.SetHeader(header)
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	table.SetHeaderAlignment(tablewriter.ALIGN_CENTER)
+	table.SetColumnAlignment(alignment)
+
+	for _, r := range results {
+		row := []string{
+			r.Resolver.Name,
+			r.Address.String(),
+			formatDuration(r.FirstLookup.Stats.Mean),
+			formatDuration(r.FirstLookup.Stats.P95),
+			formatDuration(r.RepeatLookup.Stats.Mean),
+			formatDuration(r.RepeatLookup.Stats.P95),
+		}
+		if uncached {
+			if u := r.Uncached; u != nil {
+				row = append(row,
+					formatDuration(u.Stats.Mean),
+					formatDuration(u.Stats.P95),
+					fmt.Sprintf("%.1f%%", successPercent(u.Successes, u.Queries)),
+				)
+			} else {
+				row = append(row, "-", "-", "-")
+			}
+		}
+		table.Append(row)
+	}
+	table.Render()
+	return nil
+}
+
+// formatConsistency lists resolvers whose answers disagreed with the other
+// resolvers or with the reference, when answers were checked. Only flagged
+// resolvers are ranked last; the rest merely returned different addresses.
+func (f *TableFormatter) formatConsistency(results []benchmark.ResolverResult) error {
+	if !hasConsistency(results) {
+		return nil
+	}
+
+	var rows [][]string
+	for _, r := range results {
+		c := r.Consistency
+		if c == nil || c.OK() {
+			continue
+		}
+		var issues []string
+		for _, d := range c.Divergent {
+			issues = append(issues, "divergent: "+d)
+		}
+		for _, e := range c.Empty {
+			issues = append(issues, "empty: "+e)
+		}
+		for _, s := range c.Suspicious {
+			issues = append(issues, "suspicious: "+s)
+		}
+		rows = append(rows, []string{
+			r.Resolver.Name,
+			r.Address.String(),
+			fmt.Sprintf("%d/%d", c.Agreed, c.Checked),
+			yesNo(!c.Trustworthy()),
+			strings.Join(issues, "\n"),
+		})
+	}
+	if len(rows) == 0 {
+		fmt.Fprintln(f.writer, "\nAll resolvers returned consistent answers.")
+		return nil
+	}
+
+	fmt.Fprintln(f.writer, "\nInconsistent answers (flagged resolvers are ranked after trustworthy ones):")
+	table := tablewriter.NewWriter(f.writer)
+	table.SetHeader([]string{"Resolver", "Address", "Agreed", "Flagged", "Issues"})
+	table.SetBorder(true)
+	table.SetRowLine(true)
+	table.SetAutoWrapText(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_CENTER)
+	table.SetColumnAlignment([]int{
+		tablewriter.ALIGN_LEFT,   // Resolver
+		tablewriter.ALIGN_LEFT,   // Address
+		tablewriter.ALIGN_RIGHT,  // Agreed
+		tablewriter.ALIGN_CENTER, // Flagged
+		tablewriter.ALIGN_LEFT,   // Issues
+	})
+	table.AppendBulk(rows)
+	table.Render()
+	return nil
+}
+
+// formatNXDomain lists resolvers that answered nonexistent names with an
+// address, when nonexistent names were probed
+func (f *TableFormatter) formatNXDomain(results []benchmark.ResolverResult) error {
+	if !hasNXDomain(results) {
+		return nil
+	}
+
+	var rows [][]string
+	for _, r := range results {
+		nx := r.NXDomain
+		if nx == nil || nx.OK() {
+			continue
+		}
+		rows = append(rows, []string{
+			r.Resolver.Name,
+			r.Address.String(),
+			fmt.Sprintf("%d/%d", nx.Hijacked, nx.Probes),
+			strings.Join(nx.Redirects, ", "),
+		})
+	}
+	if len(rows) == 0 {
+		fmt.Fprintln(f.writer, "\nNo resolver rewrote NXDOMAIN for nonexistent names.")
+		return nil
+	}
+
+	fmt.Fprintln(f.writer, "\nNXDOMAIN hijacking (nonexistent names answered with an address):")
+	table := tablewriter.NewWriter(f.writer)
+	table.SetHeader([]string{"Resolver", "Address", "Hijacked", "Redirects To"})
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_CENTER)
+	table.SetColumnAlignment([]int{
+		tablewriter.ALIGN_LEFT,  // Resolver
+		tablewriter.ALIGN_LEFT,  // Address
+		tablewriter.ALIGN_RIGHT, // Hijacked
+		tablewriter.ALIGN_LEFT,  // Redirects To
+	})
+	table.AppendBulk(rows)
+	table.Render()
+	return nil
+}
+
+// formatIdentity renders the anycast site and egress address of each
+// resolver address, when resolvers were asked to identify themselves
+func (f *TableFormatter) formatIdentity(results []benchmark.ResolverResult) error {
+	if !hasIdentity(results) {
+		return nil
+	}
+
+	fmt.Fprintln(f.writer, "\nResolver identity (anycast site and egress address):")
+	table := tablewriter.NewWriter(f.writer)
+	table.SetHeader([]string{"Resolver", "Address", "PoP", "Egress IP"})
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_CENTER)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+
+	for _, r := range results {
+		id := r.Identity
+		if id == nil {
+			continue
+		}
+		pop, egress := id.PoP, id.EgressIP
+		if pop == "" {
+			pop = "-"
+		}
+		if egress == "" {
+			egress = "-"
+		}
+		table.Append([]string{r.Resolver.Name, r.Address.String(), pop, egress})
+	}
+	table.Render()
+	return nil
+}
+
+// formatECS renders how far away the answered addresses are with and
+// without EDNS Client Subnet, when that was measured
+func (f *TableFormatter) formatECS(results []benchmark.ResolverResult) error {
+	if !hasECS(results) {
+		return nil
+	}
+
+	fmt.Fprintln(f.writer, "\nEDNS Client Subnet effect (median TCP connect time to answered addresses):")
+	table := tablewriter.NewWriter(f.writer)
+	table.SetHeader([]string{"Resolver", "Address", "Forwarded", "Changed", "Without ECS", "With ECS"})
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	table.SetHeaderAlignment(tablewriter.ALIGN_CENTER)
+	table.SetColumnAlignment([]int{
+		tablewriter.ALIGN_LEFT,  // Resolver
+		tablewriter.ALIGN_LEFT,  // Address
+		tablewriter.ALIGN_RIGHT, // Forwarded
+		tablewriter.ALIGN_RIGHT, // Changed
+		tablewriter.ALIGN_RIGHT, // Without ECS
+		tablewriter.ALIGN_RIGHT, // With ECS
+	})
+
+	for _, r := range results {
+		e := r.ECS
+		if e == nil {
+			continue
+		}
+		table.Append([]string{
+			r.Resolver.Name,
+			r.Address.String(),
+			fmt.Sprintf("%d/%d", e.Forwarded, e.Domains),
+			fmt.Sprintf("%d/%d", e.Changed, e.Domains),
+			formatDuration(e.PlainDistance),
+			formatDuration(e.ECSDistance),
+		})
+	}
+	table.Render()
+	return nil
+}
+
 // formatDuration formats duration for display
 func formatDuration(d time.Duration) string {
 	if d == 0 {
@@ -95,5 +446,5 @@ func formatDuration(d time.Duration) string {
 	if d < time.Millisecond {
 		return fmt.Sprintf("%.0fus", float64(d.Microseconds()))
 	}
-	return fmt.Sprintf("%.2fms", float64(d.Microseconds())/1000)
+	return fmt.Sprintf("%.2fms", stats.Milliseconds(d))
 }
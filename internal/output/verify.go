@@ -0,0 +1,119 @@
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/olekukonko/tablewriter"
+
+	"speeddns/internal/probe"
+)
+
+// FormatVerify writes the claimed vs observed feature report of the
+// verify command in the given format
+func FormatVerify(w io.Writer, format Format, reports []probe.Report) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reports)
+	case FormatCSV:
+		return formatVerifyCSV(w, reports)
+	default:
+		return formatVerifyTable(w, reports)
+	}
+}
+
+// verifyStatus describes whether a check matched its claim
+func verifyStatus(c probe.Check) string {
+	if c.OK() {
+		return "ok"
+	}
+	return "MISMATCH"
+}
+
+// yesNo formats a boolean feature state
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// formatVerifyTable renders one row per resolver feature
+func formatVerifyTable(w io.Writer, reports []probe.Report) error {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Resolver", "Feature", "Claimed", "Observed", "Status", "Detail"})
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetAutoMergeCellsByColumnIndex([]int{0}) // Resolver
+	table.SetRowLine(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_CENTER)
+	table.SetColumnAlignment([]int{
+		tablewriter.ALIGN_LEFT,   // Resolver
+		tablewriter.ALIGN_LEFT,   // Feature
+		tablewriter.ALIGN_CENTER, // Claimed
+		tablewriter.ALIGN_CENTER, // Observed
+		tablewriter.ALIGN_LEFT,   // Status
+		tablewriter.ALIGN_LEFT,   // Detail
+	})
+
+	mismatches, unreachable := 0, 0
+	for _, r := range reports {
+		if r.Error != "" {
+			unreachable++
+			table.Append([]string{r.Resolver.Name, "-", "-", "-", "ERROR", r.Error})
+			continue
+		}
+		for _, c := range r.Checks {
+			table.Append([]string{
+				r.Resolver.Name,
+				c.Feature,
+				yesNo(c.Claimed),
+				yesNo(c.Observed),
+				verifyStatus(c),
+				c.Detail,
+			})
+		}
+		mismatches += r.Mismatches()
+	}
+	table.Render()
+
+	fmt.Fprintf(w, "\n%d resolver(s) verified, %d mismatch(es), %d unreachable.\n",
+		len(reports)-unreachable, mismatches, unreachable)
+	return nil
+}
+
+// formatVerifyCSV writes one record per resolver feature
+func formatVerifyCSV(w io.Writer, reports []probe.Report) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write([]string{"resolver", "feature", "claimed", "observed", "status", "detail"}); err != nil {
+		return err
+	}
+	for _, r := range reports {
+		if r.Error != "" {
+			if err := cw.Write([]string{r.Resolver.Name, "", "", "", "error", r.Error}); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, c := range r.Checks {
+			row := []string{
+				r.Resolver.Name,
+				c.Feature,
+				fmt.Sprintf("%t", c.Claimed),
+				fmt.Sprintf("%t", c.Observed),
+				verifyStatus(c),
+				c.Detail,
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
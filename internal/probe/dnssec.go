@@ -0,0 +1,100 @@
+package probe
+
+import (
+	"context"
+
+	"speeddns/internal/dns"
+
+	mdns "github.com/miekg/dns"
+)
+
+// DNSSECTest names a correctly signed domain and one whose signatures are
+// deliberately broken
+type DNSSECTest struct {
+	Signed string
+	Bogus  string
+}
+
+// DefaultDNSSECTest returns public test names. dnssec-failed.org is kept
+// intentionally bogus by Comcast for exactly this purpose.
+func DefaultDNSSECTest() DNSSECTest {
+	return DNSSECTest{
+		Signed: "ietf.org",
+		Bogus:  "dnssec-failed.org",
+	}
+}
+
+// DNSSEC validation outcomes
+const (
+	DNSSECValidating  = "validating"   // AD on signed data, SERVFAIL on bogus
+	DNSSECNoAD        = "no-ad"        // rejects bogus data but never sets AD
+	DNSSECPassesBogus = "passes-bogus" // returns bogus data, so does not validate
+	DNSSECUnknown     = "unknown"      // the probes failed
+)
+
+// DNSSECResult holds the outcome of the DNSSEC probe
+type DNSSECResult struct {
+	// AD is set when the answer for the signed name was marked authenticated
+	AD bool `json:"ad"`
+	// BogusServfail is set when the bogus name was answered with SERVFAIL
+	BogusServfail bool `json:"bogus_servfail"`
+	// BogusPassed is set when the bogus name was answered with data
+	BogusPassed bool   `json:"bogus_passed"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Status summarises the result as one of the DNSSEC outcome constants
+func (r *DNSSECResult) Status() string {
+	switch {
+	case r.BogusPassed:
+		return DNSSECPassesBogus
+	case r.AD && r.BogusServfail:
+		return DNSSECValidating
+	case r.BogusServfail:
+		return DNSSECNoAD
+	default:
+		return DNSSECUnknown
+	}
+}
+
+// Validates reports whether the resolver validated both test names
+func (r *DNSSECResult) Validates() bool {
+	return r.Status() == DNSSECValidating
+}
+
+// DNSSEC queries the signed and the bogus name with the DO bit set and
+// records how the resolver treated each
+func DNSSEC(ctx context.Context, c *dns.Client, test DNSSECTest) *DNSSECResult {
+	result := &DNSSECResult{}
+
+	r, err := dnssecQuery(ctx, c, test.Signed)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.AD = r.Rcode == mdns.RcodeSuccess && r.AuthenticatedData
+
+	r, err = dnssecQuery(ctx, c, test.Bogus)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	switch {
+	case r.Rcode == mdns.RcodeServerFailure:
+		result.BogusServfail = true
+	case r.Rcode == mdns.RcodeSuccess && len(r.Answer) > 0:
+		result.BogusPassed = true
+	}
+	return result
+}
+
+// dnssecQuery sends an A query with the DO bit set, asking for validation
+func dnssecQuery(ctx context.Context, c *dns.Client, name string) (*mdns.Msg, error) {
+	m := new(mdns.Msg)
+	m.SetQuestion(mdns.Fqdn(name), mdns.TypeA)
+	m.RecursionDesired = true
+	m.AuthenticatedData = true
+	m.SetEdns0(mdns.DefaultMsgSize, true)
+	r, _, err := c.Exchange(ctx, m)
+	return r, err
+}
@@ -0,0 +1,176 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"speeddns/internal/dns"
+	"speeddns/internal/stats"
+
+	mdns "github.com/miekg/dns"
+)
+
+// maxDistanceAddrs caps how many addresses of one answer are timed
+const maxDistanceAddrs = 3
+
+// Distancer measures how far away an address is by timing a TCP connection
+// to its HTTPS port. Results are cached, since resolvers mostly hand out
+// the same CDN addresses.
+type Distancer struct {
+	timeout time.Duration
+
+	mu    sync.Mutex
+	cache map[string]time.Duration
+}
+
+// NewDistancer creates a Distancer whose connection attempts give up
+// after timeout
+func NewDistancer(timeout time.Duration) *Distancer {
+	return &Distancer{
+		timeout: timeout,
+		cache:   make(map[string]time.Duration),
+	}
+}
+
+// Measure returns the TCP connect time to addr, or false when it could not
+// be reached
+func (d *Distancer) Measure(ctx context.Context, addr string) (time.Duration, bool) {
+	d.mu.Lock()
+	rtt, ok := d.cache[addr]
+	d.mu.Unlock()
+	if ok {
+		return rtt, rtt > 0
+	}
+
+	dialer := net.Dialer{Timeout: d.timeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr, "443"))
+	if err == nil {
+		rtt = time.Since(start)
+		conn.Close()
+	}
+
+	// Failures are cached as 0 so unreachable addresses are not retried
+	d.mu.Lock()
+	d.cache[addr] = rtt
+	d.mu.Unlock()
+	return rtt, rtt > 0
+}
+
+// nearest returns the shortest connect time among the first addresses of
+// an answer, as a client would pick one of them
+func (d *Distancer) nearest(ctx context.Context, addrs []string) (time.Duration, bool) {
+	var best time.Duration
+	found := false
+	for i, a := range addrs {
+		if i == maxDistanceAddrs {
+			break
+		}
+		if rtt, ok := d.Measure(ctx, a); ok && (!found || rtt < best) {
+			best, found = rtt, true
+		}
+	}
+	return best, found
+}
+
+// ECSResult compares the answers a resolver returned with and without an
+// EDNS Client Subnet option. Distances are median TCP connect times from
+// this host to the answered addresses.
+type ECSResult struct {
+	Subnet  string `json:"subnet"`
+	Domains int    `json:"domains"`
+	// Forwarded counts responses whose ECS scope showed the answer was
+	// tailored to the subnet
+	Forwarded int `json:"forwarded"`
+	// Changed counts domains whose answer changed when ECS was sent
+	Changed int `json:"changed"`
+
+	PlainDistance time.Duration `json:"plain_distance"`
+	ECSDistance   time.Duration `json:"ecs_distance"`
+}
+
+// ECSEffect queries each domain for A records with and without an ECS
+// option for subnet, and measures how far away the answered addresses are
+func ECSEffect(ctx context.Context, c *dns.Client, domains []string, subnet netip.Prefix, d *Distancer) *ECSResult {
+	result := &ECSResult{Subnet: subnet.Masked().String()}
+	var plainRTTs, ecsRTTs []time.Duration
+
+	for _, domain := range domains {
+		if ctx.Err() != nil {
+			break
+		}
+		plain, _, err := ecsQuery(ctx, c, domain, netip.Prefix{})
+		if err != nil {
+			continue
+		}
+		tailored, scope, err := ecsQuery(ctx, c, domain, subnet)
+		if err != nil {
+			continue
+		}
+
+		result.Domains++
+		if scope > 0 {
+			result.Forwarded++
+		}
+		if !sameAddrs(plain, tailored) {
+			result.Changed++
+		}
+		if rtt, ok := d.nearest(ctx, plain); ok {
+			plainRTTs = append(plainRTTs, rtt)
+		}
+		if rtt, ok := d.nearest(ctx, tailored); ok {
+			ecsRTTs = append(ecsRTTs, rtt)
+		}
+	}
+
+	result.PlainDistance = stats.Calculate(plainRTTs).Median
+	result.ECSDistance = stats.Calculate(ecsRTTs).Median
+	return result
+}
+
+// ecsQuery sends an A query, with an ECS option when subnet is valid, and
+// returns the answered addresses and the ECS scope of the response
+func ecsQuery(ctx context.Context, c *dns.Client, domain string, subnet netip.Prefix) ([]string, int, error) {
+	m := new(mdns.Msg)
+	m.SetQuestion(mdns.Fqdn(domain), mdns.TypeA)
+	m.RecursionDesired = true
+	if subnet.IsValid() {
+		dns.SetClientSubnet(m, subnet)
+	} else {
+		m.SetEdns0(mdns.DefaultMsgSize, false)
+	}
+
+	r, _, err := c.Exchange(ctx, m)
+	if err != nil {
+		return nil, 0, err
+	}
+	var addrs []string
+	for _, rr := range r.Answer {
+		if a, ok := rr.(*mdns.A); ok {
+			addrs = append(addrs, a.A.String())
+		}
+	}
+	scope, _ := dns.ClientSubnetScope(r)
+	return addrs, scope, nil
+}
+
+// sameAddrs reports whether a and b hold the same addresses in any order
+func sameAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package probe
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"unicode"
+
+	"speeddns/internal/dns"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Identity holds what a resolver reveals about the instance that answered.
+// For anycast services this identifies the site (PoP) that was reached.
+type Identity struct {
+	// PoP is the best available site identifier: the CHAOS server ID, or
+	// the NSID when the resolver does not answer CHAOS queries
+	PoP string `json:"pop,omitempty"`
+	// NSID is the EDNS name server identifier (RFC 5001)
+	NSID string `json:"nsid,omitempty"`
+	// ServerID is the CHAOS TXT answer for id.server or hostname.bind
+	ServerID string `json:"server_id,omitempty"`
+	// EgressIP is the address the resolver used to reach authoritative
+	// servers, as reported by a whoami name
+	EgressIP string `json:"egress_ip,omitempty"`
+}
+
+// chaosNames are the CHAOS class TXT names that report a server identity,
+// in order of preference (RFC 4892)
+var chaosNames = []string{"id.server", "hostname.bind"}
+
+// Identify asks the resolver to identify itself with NSID and CHAOS
+// queries, and looks up its egress address with whoami names
+func Identify(ctx context.Context, c *dns.Client) *Identity {
+	id := &Identity{
+		NSID:     nsid(ctx, c),
+		ServerID: chaosID(ctx, c),
+		EgressIP: egressIP(ctx, c),
+	}
+	id.PoP = id.ServerID
+	if id.PoP == "" {
+		id.PoP = id.NSID
+	}
+	return id
+}
+
+// nsid sends a query with the NSID option and returns the identifier from
+// the response, decoded when it is printable text
+func nsid(ctx context.Context, c *dns.Client) string {
+	m := new(mdns.Msg)
+	m.SetQuestion(".", mdns.TypeNS)
+	m.RecursionDesired = true
+	m.SetEdns0(mdns.DefaultMsgSize, false)
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option, &mdns.EDNS0_NSID{Code: mdns.EDNS0NSID})
+
+	r, _, err := c.Exchange(ctx, m)
+	if err != nil {
+		return ""
+	}
+	opt = r.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+	for _, o := range opt.Option {
+		if n, ok := o.(*mdns.EDNS0_NSID); ok && n.Nsid != "" {
+			if b, err := hex.DecodeString(n.Nsid); err == nil && printable(string(b)) {
+				return strings.TrimSpace(string(b))
+			}
+			return n.Nsid
+		}
+	}
+	return ""
+}
+
+// chaosID returns the first CHAOS TXT identity the resolver answers
+func chaosID(ctx context.Context, c *dns.Client) string {
+	for _, name := range chaosNames {
+		m := new(mdns.Msg)
+		m.SetQuestion(mdns.Fqdn(name), mdns.TypeTXT)
+		m.Question[0].Qclass = mdns.ClassCHAOS
+
+		r, _, err := c.Exchange(ctx, m)
+		if err != nil || r.Rcode != mdns.RcodeSuccess {
+			continue
+		}
+		if txt := firstTXT(r); txt != "" {
+			return txt
+		}
+	}
+	return ""
+}
+
+// egressIP looks up Google's whoami TXT name, whose answer is the address
+// the query arrived from, falling back to Akamai's A record equivalent
+func egressIP(ctx context.Context, c *dns.Client) string {
+	m := new(mdns.Msg)
+	m.SetQuestion("o-o.myaddr.l.google.com.", mdns.TypeTXT)
+	m.RecursionDesired = true
+	if r, _, err := c.Exchange(ctx, m); err == nil {
+		// With ECS the record set also carries "edns0-client-subnet ..."
+		for _, rr := range r.Answer {
+			if t, ok := rr.(*mdns.TXT); ok && len(t.Txt) > 0 && !strings.Contains(t.Txt[0], " ") {
+				return t.Txt[0]
+			}
+		}
+	}
+
+	qr := c.Query(ctx, "whoami.akamai.net", mdns.TypeA)
+	if len(qr.Answers) > 0 {
+		return qr.Answers[0]
+	}
+	return ""
+}
+
+// firstTXT returns the text of the first TXT answer
+func firstTXT(r *mdns.Msg) string {
+	for _, rr := range r.Answer {
+		if t, ok := rr.(*mdns.TXT); ok {
+			return strings.Join(t.Txt, "")
+		}
+	}
+	return ""
+}
+
+// printable reports whether s is non-empty printable text
+func printable(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package probe
+
+import (
+	"net"
+	"net/netip"
+)
+
+// LocalRoute is the local interface and source address the system uses to
+// reach resolvers of one address family
+type LocalRoute struct {
+	Family    string `json:"family"`
+	Interface string `json:"interface,omitempty"`
+	SourceIP  string `json:"source_ip"`
+}
+
+// LocalRoutes looks up the route to the first IPv4 and the first IPv6
+// address given. Connecting a UDP socket selects a source address without
+// sending anything; families without a route are left out.
+func LocalRoutes(addrs []netip.Addr) []LocalRoute {
+	var routes []LocalRoute
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		family := "ipv4"
+		if !addr.Unmap().Is4() {
+			family = "ipv6"
+		}
+		if seen[family] {
+			continue
+		}
+		seen[family] = true
+
+		conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, 53)))
+		if err != nil {
+			continue
+		}
+		src := conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap()
+		conn.Close()
+
+		routes = append(routes, LocalRoute{
+			Family:    family,
+			Interface: interfaceOf(src),
+			SourceIP:  src.String(),
+		})
+	}
+	return routes
+}
+
+// interfaceOf returns the name of the interface holding addr, or "" when
+// it cannot be found
+func interfaceOf(addr netip.Addr) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				if ip, ok := netip.AddrFromSlice(ipnet.IP); ok && ip.Unmap() == addr.WithZone("") {
+					return iface.Name
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package probe
+
+import (
+	"context"
+
+	"speeddns/internal/dns"
+
+	mdns "github.com/miekg/dns"
+)
+
+// NXDomainResult holds the outcome of querying names that do not exist
+type NXDomainResult struct {
+	Probes   int `json:"probes"`
+	NXDomain int `json:"nxdomain"`
+	Hijacked int `json:"hijacked"`
+
+	// Redirects lists the addresses returned for nonexistent names,
+	// typically an ad or search landing page
+	Redirects []string `json:"redirects,omitempty"`
+}
+
+// OK reports whether no nonexistent name was answered with an address
+func (r *NXDomainResult) OK() bool {
+	return r.Hijacked == 0
+}
+
+// nxdomainNames returns fresh random names under real TLDs. Hijacking
+// resolvers often only rewrite names that look like typos of real sites,
+// so a www-prefixed name is included.
+func nxdomainNames() []string {
+	return []string{
+		dns.RandomLabel() + ".com",
+		"www." + dns.RandomLabel() + ".net",
+		dns.RandomLabel() + ".org",
+	}
+}
+
+// NXDomain queries names that cannot exist and counts how many were
+// answered NOERROR with an address instead of NXDOMAIN. Timeouts and other
+// response codes count as neither.
+func NXDomain(ctx context.Context, c *dns.Client) *NXDomainResult {
+	result := &NXDomainResult{}
+	seen := map[string]bool{}
+	for _, name := range nxdomainNames() {
+		if ctx.Err() != nil {
+			break
+		}
+		qr := c.Query(ctx, name, mdns.TypeA)
+		result.Probes++
+		switch {
+		case qr.Error != nil:
+		case qr.ResponseCode == mdns.RcodeNameError:
+			result.NXDomain++
+		case qr.ResponseCode == mdns.RcodeSuccess && len(qr.Answers) > 0:
+			result.Hijacked++
+			for _, a := range qr.Answers {
+				if !seen[a] {
+					seen[a] = true
+					result.Redirects = append(result.Redirects, a)
+				}
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,18 @@
+package probe
+
+import "net/netip"
+
+// cgnat is the RFC 6598 shared address space, used by some ISP block pages
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// IsSinkhole reports whether addr is an address no public name should
+// resolve to, as returned by filtering and hijacking resolvers
+func IsSinkhole(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	return ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || cgnat.Contains(ip)
+}
@@ -0,0 +1,244 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"speeddns/internal/dns"
+	"speeddns/internal/resolver"
+
+	mdns "github.com/miekg/dns"
+)
+
+// controlDomain is queried first to confirm the resolver is reachable
+const controlDomain = "example.com"
+
+// FilterTest describes a filtering feature and the test domains a resolver
+// offering it is expected to block. Any of the aliases in a resolver's
+// Features claims the feature.
+type FilterTest struct {
+	Feature string
+	Aliases []string
+	Domains []string
+}
+
+// DefaultFilterTests returns test domains published by filtering providers
+// for checking that their filters are active
+func DefaultFilterTests() []FilterTest {
+	return []FilterTest{
+		{
+			Feature: "Malware-blocking",
+			Aliases: []string{"Malware-blocking", "Threat-blocking", "Security", "Phishing-protection"},
+			Domains: []string{"malware.testcategory.com", "isitblocked.org", "internetbadguys.com"},
+		},
+		{
+			Feature: "Content-filtering",
+			Aliases: []string{"Content-filtering"},
+			Domains: []string{"nudity.testcategory.com", "pornhub.com"},
+		},
+		{
+			Feature: "Ad-blocking",
+			Aliases: []string{"Ad-blocking"},
+			Domains: []string{"ad.doubleclick.net", "googleadservices.com"},
+		},
+	}
+}
+
+// Check is the outcome of verifying one feature
+type Check struct {
+	Feature  string `json:"feature"`
+	Claimed  bool   `json:"claimed"`
+	Observed bool   `json:"observed"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// OK reports whether the observed behaviour matches the claim
+func (c Check) OK() bool {
+	return c.Claimed == c.Observed
+}
+
+// Report holds the feature checks for one resolver
+type Report struct {
+	Resolver resolver.Resolver `json:"resolver"`
+	Checks   []Check           `json:"checks"`
+	// Error is set when the resolver could not be reached over plain DNS,
+	// in which case no features were checked
+	Error string `json:"error,omitempty"`
+}
+
+// Mismatches returns the number of checks whose claim was wrong
+func (r Report) Mismatches() int {
+	n := 0
+	for _, c := range r.Checks {
+		if !c.OK() {
+			n++
+		}
+	}
+	return n
+}
+
+// Verify checks the resolver's claimed Features against its behaviour:
+// encrypted transports are queried, DNSSEC is probed, DNS64 synthesis is
+// tested with ipv4only.arpa and filters with known test domains
+func Verify(ctx context.Context, res resolver.Resolver, opts dns.Options) Report {
+	report := Report{Resolver: res}
+
+	addr := res.PrimaryAddress()
+	plain, err := dns.NewClient(dns.Endpoint{Protocol: dns.ProtoUDP, Host: addr}, opts)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	defer plain.Close()
+
+	if qr := plain.Query(ctx, controlDomain, mdns.TypeA); !qr.Success {
+		report.Error = fmt.Sprintf("%s unreachable: %s", addr, queryError(qr))
+		return report
+	}
+
+	// As in the benchmark, DoQ is only tried on resolvers that advertise
+	// it; a TLS name alone does not mean there is a QUIC listener
+	doqName := ""
+	if res.HasFeature("DoQ") {
+		doqName = res.TLSName
+	}
+	report.Checks = append(report.Checks,
+		transportCheck(ctx, res, "DoH", res.DoH, opts),
+		transportCheck(ctx, res, "DoT", res.TLSName, opts),
+		transportCheck(ctx, res, "DoQ", doqName, opts),
+	)
+
+	dnssec := DNSSEC(ctx, plain, DefaultDNSSECTest())
+	report.Checks = append(report.Checks, Check{
+		Feature:  "DNSSEC",
+		Claimed:  res.HasFeature("DNSSEC"),
+		Observed: dnssec.Validates(),
+		Detail:   dnssec.Status(),
+	})
+
+	report.Checks = append(report.Checks, dns64Check(ctx, res, plain))
+
+	for _, ft := range DefaultFilterTests() {
+		report.Checks = append(report.Checks, filterCheck(ctx, res, plain, ft))
+	}
+	return report
+}
+
+// transportCheck queries the control domain over an encrypted transport.
+// config is the resolver's DoH URL or TLS name; without it the transport
+// is not tested and counts as unsupported.
+func transportCheck(ctx context.Context, res resolver.Resolver, feature, config string, opts dns.Options) Check {
+	check := Check{Feature: feature, Claimed: res.HasFeature(feature)}
+	if config == "" {
+		check.Detail = "not configured"
+		if !check.Claimed {
+			check.Detail = "not advertised"
+		}
+		return check
+	}
+
+	var ep dns.Endpoint
+	switch feature {
+	case "DoH":
+		var err error
+		if ep, err = dns.ParseEndpoint(config); err != nil {
+			check.Detail = err.Error()
+			return check
+		}
+	case "DoT":
+		ep = dns.Endpoint{Protocol: dns.ProtoDoT, Host: res.PrimaryAddress(), ServerName: config}
+	case "DoQ":
+		ep = dns.Endpoint{Protocol: dns.ProtoDoQ, Host: res.PrimaryAddress(), ServerName: config}
+	}
+	check.Detail = ep.String()
+
+	client, err := dns.NewClient(ep, opts)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	defer client.Close()
+
+	qr := client.Query(ctx, controlDomain, mdns.TypeA)
+	check.Observed = qr.Success
+	if !qr.Success {
+		check.Detail = queryError(qr)
+	}
+	return check
+}
+
+// dns64Check asks for AAAA records of ipv4only.arpa (RFC 7050), which has
+// none unless the resolver synthesizes them
+func dns64Check(ctx context.Context, res resolver.Resolver, c *dns.Client) Check {
+	check := Check{Feature: "DNS64", Claimed: res.HasFeature("DNS64")}
+	qr := c.Query(ctx, "ipv4only.arpa", mdns.TypeAAAA)
+	if qr.Error != nil {
+		check.Detail = queryError(qr)
+		return check
+	}
+	check.Observed = len(qr.Answers) > 0
+	if check.Observed {
+		check.Detail = qr.Answers[0]
+	}
+	return check
+}
+
+// filterCheck queries the test domains and counts the feature as observed
+// when any of them is blocked
+func filterCheck(ctx context.Context, res resolver.Resolver, c *dns.Client, ft FilterTest) Check {
+	check := Check{Feature: ft.Feature}
+	for _, alias := range ft.Aliases {
+		if res.HasFeature(alias) {
+			check.Claimed = true
+		}
+	}
+	for _, domain := range ft.Domains {
+		qr := c.Query(ctx, domain, mdns.TypeA)
+		if blocked(qr) {
+			check.Observed = true
+			check.Detail = domain + " blocked"
+			break
+		}
+	}
+	return check
+}
+
+// blockPages are public addresses that filtering resolvers answer with
+// instead of the real one, to show a block page
+var blockPages = []netip.Prefix{
+	netip.MustParsePrefix("146.112.61.0/24"), // OpenDNS
+}
+
+// blocked reports whether a response is a filtering resolver's block:
+// NXDOMAIN, REFUSED, a sinkhole address or a block page
+func blocked(qr dns.QueryResult) bool {
+	if qr.Error != nil {
+		return false
+	}
+	switch qr.ResponseCode {
+	case mdns.RcodeNameError, mdns.RcodeRefused:
+		return true
+	}
+	for _, a := range qr.Answers {
+		if IsSinkhole(a) {
+			return true
+		}
+		if ip, err := netip.ParseAddr(a); err == nil {
+			for _, p := range blockPages {
+				if p.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// queryError describes why a query did not succeed
+func queryError(qr dns.QueryResult) string {
+	if qr.Error != nil {
+		return qr.Error.Error()
+	}
+	return mdns.RcodeToString[qr.ResponseCode]
+}
@@ -6,6 +6,9 @@ type Resolver struct {
 	Provider    string   `json:"provider"`
 	IPv4        []string `json:"ipv4"`
 	IPv6        []string `json:"ipv6,omitempty"`
+	DoH         string   `json:"doh,omitempty"`
+	TLSName     string   `json:"tls_name,omitempty"`
+	Endpoints   []string `json:"endpoints,omitempty"`
 	Description string   `json:"description"`
 	Features    []string `json:"features,omitempty"`
 }
@@ -21,6 +24,16 @@ func (r Resolver) PrimaryAddress() string {
 	return ""
 }
 
+// HasFeature reports whether the resolver advertises the named feature
+func (r Resolver) HasFeature(feature string) bool {
+	for _, f := range r.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
 // AllAddresses returns all IP addresses (IPv4 and optionally IPv6)
 func (r Resolver) AllAddresses(includeIPv6 bool) []string {
 	addrs := make([]string, 0, len(r.IPv4)+len(r.IPv6))
@@ -39,6 +52,8 @@ func BuiltinResolvers() []Resolver {
 			Provider:    "Cloudflare, Inc.",
 			IPv4:        []string{"1.1.1.1", "1.0.0.1"},
 			IPv6:        []string{"2606:4700:4700::1111", "2606:4700:4700::1001"},
+			DoH:         "https://cloudflare-dns.com/dns-query",
+			TLSName:     "one.one.one.one",
 			Description: "Privacy-focused, fastest public DNS",
 			Features:    []string{"DoH", "DoT", "DNSSEC"},
 		},
@@ -47,6 +62,8 @@ func BuiltinResolvers() []Resolver {
 			Provider:    "Google LLC",
 			IPv4:        []string{"8.8.8.8", "8.8.4.4"},
 			IPv6:        []string{"2001:4860:4860::8888", "2001:4860:4860::8844"},
+			DoH:         "https://dns.google/dns-query",
+			TLSName:     "dns.google",
 			Description: "Google Public DNS, high availability",
 			Features:    []string{"DoH", "DoT", "DNSSEC", "DNS64"},
 		},
@@ -55,6 +72,8 @@ func BuiltinResolvers() []Resolver {
 			Provider:    "Quad9 Foundation",
 			IPv4:        []string{"9.9.9.9", "149.112.112.112"},
 			IPv6:        []string{"2620:fe::fe", "2620:fe::9"},
+			DoH:         "https://dns.quad9.net/dns-query",
+			TLSName:     "dns.quad9.net",
 			Description: "Security-focused with malware blocking",
 			Features:    []string{"DoH", "DoT", "DNSSEC", "Threat-blocking"},
 		},
@@ -63,6 +82,8 @@ func BuiltinResolvers() []Resolver {
 			Provider:    "Quad9 Foundation",
 			IPv4:        []string{"9.9.9.10", "149.112.112.10"},
 			IPv6:        []string{"2620:fe::10", "2620:fe::fe:10"},
+			DoH:         "https://dns10.quad9.net/dns-query",
+			TLSName:     "dns10.quad9.net",
 			Description: "Quad9 without security filtering",
 			Features:    []string{"DoH", "DoT", "DNSSEC"},
 		},
@@ -71,6 +92,7 @@ func BuiltinResolvers() []Resolver {
 			Provider:    "Cisco Systems",
 			IPv4:        []string{"208.67.222.222", "208.67.220.220"},
 			IPv6:        []string{"2620:119:35::35", "2620:119:53::53"},
+			DoH:         "https://doh.opendns.com/dns-query",
 			Description: "OpenDNS with security features",
 			Features:    []string{"DoH", "DNSSEC", "Phishing-protection"},
 		},
@@ -86,6 +108,8 @@ func BuiltinResolvers() []Resolver {
 			Provider:    "Cloudflare, Inc.",
 			IPv4:        []string{"1.1.1.2", "1.0.0.2"},
 			IPv6:        []string{"2606:4700:4700::1112", "2606:4700:4700::1002"},
+			DoH:         "https://security.cloudflare-dns.com/dns-query",
+			TLSName:     "security.cloudflare-dns.com",
 			Description: "Cloudflare with malware blocking",
 			Features:    []string{"DoH", "DoT", "Malware-blocking"},
 		},
@@ -94,6 +118,8 @@ func BuiltinResolvers() []Resolver {
 			Provider:    "Cloudflare, Inc.",
 			IPv4:        []string{"1.1.1.3", "1.0.0.3"},
 			IPv6:        []string{"2606:4700:4700::1113", "2606:4700:4700::1003"},
+			DoH:         "https://family.cloudflare-dns.com/dns-query",
+			TLSName:     "family.cloudflare-dns.com",
 			Description: "Cloudflare with malware and adult content blocking",
 			Features:    []string{"DoH", "DoT", "Content-filtering"},
 		},
@@ -102,22 +128,28 @@ func BuiltinResolvers() []Resolver {
 			Provider:    "AdGuard Software Ltd.",
 			IPv4:        []string{"94.140.14.14", "94.140.15.15"},
 			IPv6:        []string{"2a10:50c0::ad1:ff", "2a10:50c0::ad2:ff"},
+			DoH:         "https://dns.adguard-dns.com/dns-query",
+			TLSName:     "dns.adguard-dns.com",
 			Description: "Ad-blocking DNS service",
-			Features:    []string{"DoH", "DoT", "Ad-blocking"},
+			Features:    []string{"DoH", "DoT", "DoQ", "Ad-blocking"},
 		},
 		{
 			Name:        "AdGuard-Family",
 			Provider:    "AdGuard Software Ltd.",
 			IPv4:        []string{"94.140.14.15", "94.140.15.16"},
 			IPv6:        []string{"2a10:50c0::bad1:ff", "2a10:50c0::bad2:ff"},
+			DoH:         "https://family.adguard-dns.com/dns-query",
+			TLSName:     "family.adguard-dns.com",
 			Description: "AdGuard with family protection",
-			Features:    []string{"DoH", "DoT", "Ad-blocking", "Content-filtering"},
+			Features:    []string{"DoH", "DoT", "DoQ", "Ad-blocking", "Content-filtering"},
 		},
 		{
 			Name:        "CleanBrowsing-Security",
 			Provider:    "CleanBrowsing",
 			IPv4:        []string{"185.228.168.9", "185.228.169.9"},
 			IPv6:        []string{"2a0d:2a00:1::2", "2a0d:2a00:2::2"},
+			DoH:         "https://doh.cleanbrowsing.org/doh/security-filter/",
+			TLSName:     "security-filter-dns.cleanbrowsing.org",
 			Description: "Security filter, blocks malware",
 			Features:    []string{"DoH", "DoT", "Security"},
 		},
@@ -126,6 +158,8 @@ func BuiltinResolvers() []Resolver {
 			Provider:    "CleanBrowsing",
 			IPv4:        []string{"185.228.168.168", "185.228.169.168"},
 			IPv6:        []string{"2a0d:2a00:1::", "2a0d:2a00:2::"},
+			DoH:         "https://doh.cleanbrowsing.org/doh/family-filter/",
+			TLSName:     "family-filter-dns.cleanbrowsing.org",
 			Description: "Family filter with content blocking",
 			Features:    []string{"DoH", "DoT", "Content-filtering"},
 		},
@@ -161,6 +195,8 @@ func BuiltinResolvers() []Resolver {
 			Provider:    "dns0.eu",
 			IPv4:        []string{"193.110.81.0", "185.253.5.0"},
 			IPv6:        []string{"2a0f:fc80::", "2a0f:fc81::"},
+			DoH:         "https://dns0.eu/",
+			TLSName:     "dns0.eu",
 			Description: "European privacy-focused DNS",
 			Features:    []string{"DoH", "DoT", "DNSSEC"},
 		},
@@ -169,6 +205,8 @@ func BuiltinResolvers() []Resolver {
 			Provider:    "Mullvad VPN AB",
 			IPv4:        []string{"194.242.2.2"},
 			IPv6:        []string{"2a07:e340::2"},
+			DoH:         "https://dns.mullvad.net/dns-query",
+			TLSName:     "dns.mullvad.net",
 			Description: "Mullvad public DNS",
 			Features:    []string{"DoH", "DoT", "Ad-blocking"},
 		},
@@ -177,16 +215,20 @@ func BuiltinResolvers() []Resolver {
 			Provider:    "Control D",
 			IPv4:        []string{"76.76.2.0", "76.76.10.0"},
 			IPv6:        []string{"2606:1a40::", "2606:1a40:1::"},
+			DoH:         "https://freedns.controld.com/p0",
+			TLSName:     "p0.freedns.controld.com",
 			Description: "Control D public DNS",
-			Features:    []string{"DoH", "DoT"},
+			Features:    []string{"DoH", "DoT", "DoQ"},
 		},
 		{
 			Name:        "NextDNS",
 			Provider:    "NextDNS Inc.",
 			IPv4:        []string{"45.90.28.0", "45.90.30.0"},
 			IPv6:        []string{"2a07:a8c0::", "2a07:a8c1::"},
+			DoH:         "https://dns.nextdns.io/",
+			TLSName:     "dns.nextdns.io",
 			Description: "NextDNS public resolver",
-			Features:    []string{"DoH", "DoT", "Customizable"},
+			Features:    []string{"DoH", "DoT", "DoQ", "Customizable"},
 		},
 		{
 			Name:        "Verisign",
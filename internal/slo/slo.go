@@ -0,0 +1,337 @@
+package slo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"speeddns/internal/benchmark"
+	"speeddns/internal/dns"
+	"speeddns/internal/stats"
+)
+
+// Metric names a value that rules can assert on
+type Metric string
+
+const (
+	MetricAvg     Metric = "avg"
+	MetricMedian  Metric = "median"
+	MetricP90     Metric = "p90"
+	MetricP95     Metric = "p95"
+	MetricP99     Metric = "p99"
+	MetricMax     Metric = "max"
+	MetricSuccess Metric = "success_rate"
+)
+
+// latency reports whether a metric is a latency in milliseconds rather
+// than a percentage
+func (m Metric) latency() bool {
+	return m != MetricSuccess
+}
+
+// unit returns the suffix values of the metric are shown with
+func (m Metric) unit() string {
+	if m.latency() {
+		return "ms"
+	}
+	return "%"
+}
+
+// Values holds the metrics of one resolver address, latencies in
+// milliseconds and rates in percent. Latencies are missing when no query
+// succeeded.
+type Values map[Metric]float64
+
+// ResultValues returns the metrics of a benchmark result
+func ResultValues(r benchmark.ResolverResult) Values {
+	v := Values{MetricSuccess: 0}
+	if r.Queries > 0 {
+		v[MetricSuccess] = float64(r.Successes) / float64(r.Queries) * 100
+	}
+	if r.Stats.Count > 0 {
+		v[MetricAvg] = stats.Milliseconds(r.Stats.Mean)
+		v[MetricMedian] = stats.Milliseconds(r.Stats.Median)
+		v[MetricP90] = stats.Milliseconds(r.Stats.P90)
+		v[MetricP95] = stats.Milliseconds(r.Stats.P95)
+		v[MetricP99] = stats.Milliseconds(r.Stats.P99)
+		v[MetricMax] = stats.Milliseconds(r.Stats.Max)
+	}
+	return v
+}
+
+// Rule asserts that a metric of every matching resolver address compares
+// to a limit. The limit is absolute, or relative to the same address in a
+// baseline run.
+type Rule struct {
+	Text     string // the rule as written
+	Resolver string // resolver name or address, empty for all
+	Metric   Metric
+	Op       string // <, <=, > or >=
+
+	Limit float64 // absolute limit, when not relative to the baseline
+
+	// Baseline makes the limit the baseline's value, adjusted by
+	// BaselinePercent percent and then BaselineOffset
+	Baseline        bool
+	BaselinePercent float64
+	BaselineOffset  float64
+}
+
+// ruleRe matches "[resolver:] metric op value". The resolver ends at the
+// first colon followed by a metric and operator, so colons in URLs and IPv6
+// addresses are kept.
+var ruleRe = regexp.MustCompile(`^(?:(.+?):\s*)?([a-z0-9_]+)\s*(<=|>=|<|>|≤|≥)\s*(\S+)$`)
+
+// Parse parses a rule such as "p95 < 40ms", "success_rate >= 99.5%",
+// "Cloudflare: avg < 20ms" or "p95 <= baseline+10%"
+func Parse(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	m := ruleRe.FindStringSubmatch(s)
+	if m == nil {
+		return Rule{}, fmt.Errorf("invalid rule %q: want [resolver:] metric op value", s)
+	}
+
+	rule := Rule{Text: s, Resolver: strings.TrimSpace(m[1]), Metric: Metric(m[2]), Op: m[3]}
+	switch rule.Op {
+	case "≤":
+		rule.Op = "<="
+	case "≥":
+		rule.Op = ">="
+	}
+	switch rule.Metric {
+	case MetricAvg, MetricMedian, MetricP90, MetricP95, MetricP99, MetricMax, MetricSuccess:
+	default:
+		return Rule{}, fmt.Errorf("invalid rule %q: unknown metric %q (want avg, median, p90, p95, p99, max or success_rate)", s, m[2])
+	}
+
+	value := m[4]
+	if rest, ok := strings.CutPrefix(value, "baseline"); ok {
+		rule.Baseline = true
+		if rest == "" {
+			return rule, nil
+		}
+		if rest[0] != '+' && rest[0] != '-' {
+			return Rule{}, fmt.Errorf("invalid rule %q: want baseline, baseline+N%% or baseline+N%s", s, rule.Metric.unit())
+		}
+		if pct, ok := strings.CutSuffix(rest, "%"); ok && rule.Metric.latency() {
+			// For latencies a percentage is relative to the baseline; for
+			// success rates it is an absolute offset in percentage points
+			n, err := strconv.ParseFloat(pct, 64)
+			if err != nil {
+				return Rule{}, fmt.Errorf("invalid rule %q: %w", s, err)
+			}
+			rule.BaselinePercent = n
+			return rule, nil
+		}
+		n, err := parseValue(rule.Metric, rest)
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid rule %q: %w", s, err)
+		}
+		rule.BaselineOffset = n
+		return rule, nil
+	}
+
+	n, err := parseValue(rule.Metric, value)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid rule %q: %w", s, err)
+	}
+	rule.Limit = n
+	return rule, nil
+}
+
+// parseValue parses a limit in the metric's unit: a duration such as 40ms
+// for latencies and a percentage for rates. Bare numbers are taken to be
+// in the metric's unit.
+func parseValue(metric Metric, s string) (float64, error) {
+	if metric.latency() {
+		if d, err := time.ParseDuration(s); err == nil {
+			return stats.Milliseconds(d), nil
+		}
+		if strings.HasSuffix(s, "%") {
+			return 0, fmt.Errorf("%s is a latency, not a percentage", metric)
+		}
+	} else {
+		s = strings.TrimSuffix(s, "%")
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value %q", metric, s)
+	}
+	return n, nil
+}
+
+// ParseRules reads rules one per line. Blank lines and lines starting with
+// # are ignored.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule, err := Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// Baseline holds the metrics of a previous run by resolver address
+type Baseline struct {
+	values map[string]Values
+}
+
+// NewBaseline creates an empty baseline
+func NewBaseline() *Baseline {
+	return &Baseline{values: make(map[string]Values)}
+}
+
+// Add records the metrics of a resolver address. Runs saved before the
+// protocol was recorded only measured plain UDP.
+func (b *Baseline) Add(name, address, protocol string, v Values) {
+	if protocol == "" {
+		protocol = string(dns.ProtoUDP)
+	}
+	b.values[baselineKey(name, address, protocol)] = v
+}
+
+// lookup returns the metrics recorded for a resolver address
+func (b *Baseline) lookup(r benchmark.ResolverResult) (Values, bool) {
+	if b == nil {
+		return nil, false
+	}
+	v, ok := b.values[baselineKey(r.Resolver.Name, r.Address.String(), string(r.Protocol))]
+	return v, ok
+}
+
+// baselineKey identifies a resolver address across runs
+func baselineKey(name, address, protocol string) string {
+	return name + "\x00" + address + "\x00" + protocol
+}
+
+// Violation is a rule that a resolver address did not meet
+type Violation struct {
+	Rule     Rule
+	Resolver string // empty when no address matched the rule
+	Address  string
+	Protocol string
+	Detail   string // what was measured against what limit
+}
+
+// Evaluate checks every rule against the results and returns the
+// violations. Rules that match no resolver, and latency rules for addresses
+// where every query failed, are violations, since they cannot be shown to
+// hold. Relative rules skip addresses missing from the baseline, but are
+// violations when no matching address is in it.
+func Evaluate(rules []Rule, results []benchmark.ResolverResult, baseline *Baseline) []Violation {
+	var violations []Violation
+	for _, rule := range rules {
+		matched, compared := false, false
+		for _, r := range results {
+			if !rule.matches(r) {
+				continue
+			}
+			matched = true
+			if _, ok := rule.baselineValue(r, baseline); ok {
+				compared = true
+			}
+			if detail, ok := rule.check(r, baseline); !ok {
+				violations = append(violations, Violation{
+					Rule:     rule,
+					Resolver: r.Resolver.Name,
+					Address:  r.Address.String(),
+					Protocol: string(r.Protocol),
+					Detail:   detail,
+				})
+			}
+		}
+		switch {
+		case !matched:
+			violations = append(violations, Violation{Rule: rule, Detail: "no resolver matched"})
+		case rule.Baseline && !compared:
+			violations = append(violations, Violation{Rule: rule, Detail: "no matching address has a baseline value"})
+		}
+	}
+	return violations
+}
+
+// UsesBaseline reports whether any rule is relative to a baseline run
+func UsesBaseline(rules []Rule) bool {
+	for _, rule := range rules {
+		if rule.Baseline {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the rule applies to a result, by resolver name
+// or address
+func (rule Rule) matches(r benchmark.ResolverResult) bool {
+	if rule.Resolver == "" {
+		return true
+	}
+	return strings.EqualFold(rule.Resolver, r.Resolver.Name) ||
+		rule.Resolver == r.Address.String() ||
+		rule.Resolver == r.Address.Host
+}
+
+// baselineValue returns the baseline's value of the rule's metric for the
+// result's address
+func (rule Rule) baselineValue(r benchmark.ResolverResult, baseline *Baseline) (float64, bool) {
+	base, ok := baseline.lookup(r)
+	if !ok {
+		return 0, false
+	}
+	v, ok := base[rule.Metric]
+	return v, ok
+}
+
+// check evaluates the rule for one result, returning a description of the
+// measured value and limit and whether it held
+func (rule Rule) check(r benchmark.ResolverResult, baseline *Baseline) (string, bool) {
+	actual, ok := ResultValues(r)[rule.Metric]
+	if !ok {
+		return "no successful queries", false
+	}
+
+	limit := rule.Limit
+	if rule.Baseline {
+		base, ok := rule.baselineValue(r, baseline)
+		if !ok {
+			return "", true
+		}
+		limit = base*(1+rule.BaselinePercent/100) + rule.BaselineOffset
+	}
+
+	var held bool
+	switch rule.Op {
+	case "<":
+		held = actual < limit
+	case "<=":
+		held = actual <= limit
+	case ">":
+		held = actual > limit
+	default:
+		held = actual >= limit
+	}
+
+	// Latencies have microsecond precision
+	precision, unit := 2, rule.Metric.unit()
+	if rule.Metric.latency() {
+		precision = 3
+	}
+	detail := fmt.Sprintf("%s %.*f%s, limit %s %.*f%s",
+		rule.Metric, precision, actual, unit, rule.Op, precision, limit, unit)
+	if rule.Baseline {
+		detail += " from baseline"
+	}
+	return detail, held
+}
@@ -0,0 +1,47 @@
+package stats
+
+import (
+	"math"
+)
+
+// MeanZ returns the z-score of the difference between two sample means
+// (b minus a), given their population standard deviations and sample sizes,
+// as in Welch's test. With fewer than two samples on either side there is
+// no evidence of a difference and 0 is returned.
+func MeanZ(meanA, stdDevA float64, nA int, meanB, stdDevB float64, nB int) float64 {
+	if nA < 2 || nB < 2 {
+		return 0
+	}
+	// Bessel's correction turns the population variances into estimates
+	varA := stdDevA * stdDevA * float64(nA) / float64(nA-1)
+	varB := stdDevB * stdDevB * float64(nB) / float64(nB-1)
+	return zScore(meanB-meanA, math.Sqrt(varA/float64(nA)+varB/float64(nB)))
+}
+
+// ProportionZ returns the z-score of the difference between two success
+// proportions (b minus a), using the pooled two-proportion test
+func ProportionZ(successesA, totalA, successesB, totalB int) float64 {
+	if totalA == 0 || totalB == 0 {
+		return 0
+	}
+	pA := float64(successesA) / float64(totalA)
+	pB := float64(successesB) / float64(totalB)
+	pooled := float64(successesA+successesB) / float64(totalA+totalB)
+	return zScore(pB-pA, math.Sqrt(pooled*(1-pooled)*(1/float64(totalA)+1/float64(totalB))))
+}
+
+// zScore divides a difference by its standard error. A difference without
+// any variance is infinitely significant, unless there is no difference.
+func zScore(diff, stdErr float64) float64 {
+	if stdErr == 0 {
+		switch {
+		case diff > 0:
+			return math.Inf(1)
+		case diff < 0:
+			return math.Inf(-1)
+		default:
+			return 0
+		}
+	}
+	return diff / stdErr
+}
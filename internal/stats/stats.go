@@ -85,3 +85,9 @@ func percentile(sorted []time.Duration, p float64) time.Duration {
 	fraction := rank - float64(lower)
 	return sorted[lower] + time.Duration(fraction*float64(sorted[upper]-sorted[lower]))
 }
+
+// Milliseconds converts a duration to fractional milliseconds, with
+// microsecond precision
+func Milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,190 @@
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// windowSlices is the number of time slices a Window divides its span
+	// into; a summary's start is rounded to a slice boundary
+	windowSlices = 300
+
+	// histogramBins is the number of latency bins kept per slice. Bins grow
+	// by histogramGrowth from 1µs, so the last one starts at about 30s.
+	histogramBins   = 200
+	histogramGrowth = 1.09
+)
+
+// Window keeps query outcomes for a trailing period, aggregated into time
+// slices, so statistics can be calculated over any part of it. Memory does
+// not grow with the query rate: each slice holds counts and a latency
+// histogram, so percentiles are approximate (within about 5%), while the
+// count, minimum, maximum and mean are exact.
+type Window struct {
+	span   time.Duration
+	width  time.Duration // time covered by one slice
+	slices []*slice
+}
+
+// slice aggregates the outcomes of queries sent within one slice width
+type slice struct {
+	start     time.Time
+	queries   int
+	successes int
+	timed     int
+	sum       float64 // of timed RTTs, in nanoseconds
+	sumSq     float64
+	min, max  time.Duration
+	histogram [histogramBins]uint32
+}
+
+// WindowSummary holds statistics over the trailing span of a Window
+type WindowSummary struct {
+	Span      time.Duration `json:"span"`
+	Queries   int           `json:"queries"`
+	Successes int           `json:"successes"`
+	Failures  int           `json:"failures"`
+	Stats     Summary       `json:"stats"`
+}
+
+// Availability returns the fraction of queries that succeeded, or 0 when
+// there were none
+func (s WindowSummary) Availability() float64 {
+	if s.Queries == 0 {
+		return 0
+	}
+	return float64(s.Successes) / float64(s.Queries)
+}
+
+// NewWindow creates a Window that keeps outcomes for span
+func NewWindow(span time.Duration) *Window {
+	width := span / windowSlices
+	if width < time.Second {
+		width = time.Second
+	}
+	return &Window{span: span, width: width}
+}
+
+// Add records a query outcome at the given time and drops outcomes older
+// than the window's span. A successful query with an RTT of 0 is counted
+// without being timed, as for queries that included connection setup.
+// Outcomes must be added in time order.
+func (w *Window) Add(at time.Time, rtt time.Duration, ok bool) {
+	var s *slice
+	if n := len(w.slices); n > 0 && at.Before(w.slices[n-1].start.Add(w.width)) {
+		s = w.slices[n-1]
+	} else {
+		s = &slice{start: at.Truncate(w.width)}
+		w.slices = append(w.slices, s)
+	}
+
+	s.queries++
+	if ok {
+		s.successes++
+		if rtt > 0 {
+			if s.timed == 0 || rtt < s.min {
+				s.min = rtt
+			}
+			if rtt > s.max {
+				s.max = rtt
+			}
+			s.timed++
+			s.sum += float64(rtt)
+			s.sumSq += float64(rtt) * float64(rtt)
+			s.histogram[histogramBin(rtt)]++
+		}
+	}
+
+	cutoff := at.Add(-w.span)
+	drop := 0
+	for drop < len(w.slices) && w.slices[drop].start.Add(w.width).Before(cutoff) {
+		drop++
+	}
+	w.slices = w.slices[drop:]
+}
+
+// Summarize calculates statistics over the outcomes from the last span
+// before now, to the slice. A span longer than the window's covers the
+// whole window.
+func (w *Window) Summarize(now time.Time, span time.Duration) WindowSummary {
+	summary := WindowSummary{Span: span}
+	cutoff := now.Add(-span)
+
+	var merged slice
+	var sum, sumSq float64
+	for _, s := range w.slices {
+		if s.start.Before(cutoff.Truncate(w.width)) || s.start.After(now) {
+			continue
+		}
+		summary.Queries += s.queries
+		summary.Successes += s.successes
+		if s.timed == 0 {
+			continue
+		}
+		if merged.timed == 0 || s.min < merged.min {
+			merged.min = s.min
+		}
+		if s.max > merged.max {
+			merged.max = s.max
+		}
+		merged.timed += s.timed
+		sum += s.sum
+		sumSq += s.sumSq
+		for i, n := range s.histogram {
+			merged.histogram[i] += n
+		}
+	}
+	summary.Failures = summary.Queries - summary.Successes
+	if merged.timed == 0 {
+		return summary
+	}
+
+	n := float64(merged.timed)
+	mean := sum / n
+	variance := math.Max(sumSq/n-mean*mean, 0)
+	summary.Stats = Summary{
+		Count:  merged.timed,
+		Min:    merged.min,
+		Max:    merged.max,
+		Mean:   time.Duration(mean),
+		Median: merged.percentile(50),
+		StdDev: time.Duration(math.Sqrt(variance)),
+		P50:    merged.percentile(50),
+		P75:    merged.percentile(75),
+		P90:    merged.percentile(90),
+		P95:    merged.percentile(95),
+		P99:    merged.percentile(99),
+	}
+	return summary
+}
+
+// percentile estimates the p-th percentile of the timed RTTs as the
+// geometric middle of the bin it falls in, kept within the minimum and
+// maximum
+func (s *slice) percentile(p float64) time.Duration {
+	rank := uint64(math.Ceil(p / 100 * float64(s.timed)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range s.histogram {
+		seen += uint64(n)
+		if seen < rank {
+			continue
+		}
+		d := time.Duration(math.Pow(histogramGrowth, float64(i)+0.5) * float64(time.Microsecond))
+		return min(max(d, s.min), s.max)
+	}
+	return s.max
+}
+
+// histogramBin returns the bin of an RTT; the last bin holds everything
+// past the histogram's range
+func histogramBin(rtt time.Duration) int {
+	us := float64(rtt) / float64(time.Microsecond)
+	if us < 1 {
+		return 0
+	}
+	return min(int(math.Log(us)/math.Log(histogramGrowth)), histogramBins-1)
+}